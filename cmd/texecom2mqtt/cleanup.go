@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/daemonp/texecom2mqtt/internal/cache"
+	"github.com/daemonp/texecom2mqtt/internal/config"
+	"github.com/daemonp/texecom2mqtt/internal/homeassistant"
+)
+
+// haCleanup removes every Home Assistant discovery config of a panel, given
+// by its serial number.
+func haCleanup(args []string) int {
+	flags := flag.NewFlagSet("ha-cleanup", flag.ExitOnError)
+	configFile := flags.String("config", config.DefaultConfigFile(), "Path to configuration file")
+	serial := flags.String("serial", "", "Serial number of the panel to remove")
+	wait := flags.Duration("wait", 3*time.Second, "How long to collect retained discovery configs")
+	flags.Parse(args)
+
+	if *serial == "" {
+		fmt.Fprintln(os.Stderr, "ha-cleanup: --serial is required")
+		flags.Usage()
+		return exitUsage
+	}
+
+	cfg, err := config.LoadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return exitFailure
+	}
+
+	cache.SetDir(cfg.CacheDir)
+	topics, err := homeassistant.Cleanup(&cfg.MQTT, &cfg.HomeAssistant, *serial, *wait)
+	for _, topic := range topics {
+		fmt.Printf("Removed %s\n", topic)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error cleaning up Home Assistant discovery: %v\n", err)
+		return exitFailure
+	}
+	fmt.Printf("Removed %d discovery config(s) of panel %s\n", len(topics), *serial)
+	return exitOK
+}
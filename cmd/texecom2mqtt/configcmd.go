@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/daemonp/texecom2mqtt/internal/config"
+)
+
+// configCommand runs the config subcommands.
+func configCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: texecom2mqtt config validate [--config file]")
+		fmt.Fprintln(os.Stderr, "       texecom2mqtt config schema")
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "validate":
+		return configValidate(args[1:])
+	case "schema":
+		return configSchema()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown config command %q\n", args[0])
+		return exitUsage
+	}
+}
+
+// configValidate loads the config, including environment overrides, and
+// reports every problem found with it.
+func configValidate(args []string) int {
+	flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	configFile := flags.String("config", config.DefaultConfigFile(), "Path to configuration file")
+	flags.Parse(args)
+
+	cfg, err := config.LoadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *configFile, err)
+		return exitFailure
+	}
+	for _, warning := range cfg.Warnings {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", *configFile, warning)
+	}
+	fmt.Printf("%s: config is valid\n", *configFile)
+	return exitOK
+}
+
+// configSchema prints the JSON Schema of the config file.
+func configSchema() int {
+	schema, err := config.Schema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating schema: %v\n", err)
+		return exitFailure
+	}
+	fmt.Println(string(schema))
+	return exitOK
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/daemonp/texecom2mqtt/internal/cache"
+	"github.com/daemonp/texecom2mqtt/internal/config"
+	"github.com/daemonp/texecom2mqtt/internal/log"
+	"github.com/daemonp/texecom2mqtt/internal/mqtt"
+	"github.com/daemonp/texecom2mqtt/internal/panel"
+	"github.com/daemonp/texecom2mqtt/internal/types"
+	"github.com/daemonp/texecom2mqtt/internal/util"
+)
+
+// Exit codes of the command line tools.
+const (
+	exitOK          = 0
+	exitFailure     = 1 // the command failed, or the panel rejected it
+	exitUsage       = 2 // bad flags or arguments
+	exitUnreachable = 3 // the panel could not be reached or logged in to
+)
+
+// controlOptions are the flags shared by the commands that talk to a panel
+// directly, without MQTT.
+type controlOptions struct {
+	configFile string
+	panel      string
+	json       bool
+	logLevel   string
+}
+
+func newControlFlags(name string) (*flag.FlagSet, *controlOptions) {
+	opts := &controlOptions{}
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(&opts.configFile, "config", config.DefaultConfigFile(), "Path to configuration file")
+	flags.StringVar(&opts.panel, "panel", "", "Name or prefix of the panel, if the config file has several")
+	flags.BoolVar(&opts.json, "json", false, "Print JSON instead of text")
+	flags.StringVar(&opts.logLevel, "log", "fatal", "Log level, errors are reported without it")
+	return flags, opts
+}
+
+// parseFlags parses args allowing flags after positional arguments, as in
+// "arm house --mode part1", and returns the positional arguments.
+func parseFlags(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// openPanel connects and logs in to the panel picked by opts and loads its
+// areas and zones and their state. It reads the layout cache but leaves
+// writing it, and the persisted state, to the bridge. The caller must
+// disconnect it.
+func openPanel(opts *controlOptions) (*panel.Panel, int) {
+	cfg, err := config.LoadConfig(opts.configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return nil, exitFailure
+	}
+	panelCfg := findPanel(cfg, opts.panel)
+	if panelCfg == nil {
+		if opts.panel == "" {
+			fmt.Fprintf(os.Stderr, "%s has %d panels, pick one with --panel\n", opts.configFile, len(cfg.Panels))
+		} else {
+			fmt.Fprintf(os.Stderr, "No panel %q in %s\n", opts.panel, opts.configFile)
+		}
+		return nil, exitUsage
+	}
+
+	cache.SetDir(cfg.CacheDir)
+	logger := log.NewLoggerTo(opts.logLevel, os.Stderr)
+	p := panel.NewPanel(panelCfg, logger)
+	if cfg.Cache {
+		p.UseCachedLayout()
+	}
+	if err := startPanel(p); err != nil {
+		p.Disconnect()
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return nil, exitUnreachable
+	}
+	return p, exitOK
+}
+
+func printJSON(v interface{}) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// status prints the panel identification, its areas and a summary of its
+// zones.
+func status(args []string) int {
+	flags, opts := newControlFlags("status")
+	if len(parseFlags(flags, args)) > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: texecom2mqtt status [flags]")
+		return exitUsage
+	}
+
+	p, code := openPanel(opts)
+	if p == nil {
+		return code
+	}
+	defer p.Disconnect()
+
+	device := p.GetDevice()
+	areas := p.GetAreas()
+	zones := usedZones(p.GetZones())
+
+	var active, tampered, bypassed, problem int
+	for _, zone := range zones {
+		switch zone.Status {
+		case types.ZoneStateActive:
+			active++
+		case types.ZoneStateTampered:
+			tampered++
+		}
+		if zone.Flags.Bypassed() {
+			bypassed++
+		}
+		if zone.Flags.Problem() {
+			problem++
+		}
+	}
+
+	if opts.json {
+		areaPayloads := make([]map[string]interface{}, 0, len(areas))
+		for _, area := range areas {
+			areaPayloads = append(areaPayloads, mqtt.AreaPayload(area))
+		}
+		return printJSON(map[string]interface{}{
+			"model":            device.Model,
+			"serial_number":    device.SerialNumber,
+			"firmware_version": device.FirmwareVersion,
+			"areas":            areaPayloads,
+			"zones": map[string]int{
+				"total":    len(zones),
+				"active":   active,
+				"tampered": tampered,
+				"bypassed": bypassed,
+				"problem":  problem,
+			},
+		})
+	}
+
+	fmt.Printf("Panel:  %s, serial number %s, firmware %s\n", device.Model, device.SerialNumber, device.FirmwareVersion)
+	fmt.Println("Areas:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, area := range areas {
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", area.ID, area.Name, types.GetAreaStatus(area))
+	}
+	w.Flush()
+	fmt.Printf("Zones:  %d in use, %d active, %d tampered, %d bypassed, %d with problems\n",
+		len(zones), active, tampered, bypassed, problem)
+	return exitOK
+}
+
+// usedZones leaves out the zones whose type is Not used.
+func usedZones(zones []types.Zone) []types.Zone {
+	used := make([]types.Zone, 0, len(zones))
+	for _, zone := range zones {
+		if zone.Type != types.ZoneTypeNotUsed {
+			used = append(used, zone)
+		}
+	}
+	return used
+}
+
+func listZones(args []string) int {
+	flags, opts := newControlFlags("zones")
+	all := flags.Bool("all", false, "Include zones that are not used")
+	if len(parseFlags(flags, args)) > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: texecom2mqtt zones [flags]")
+		return exitUsage
+	}
+
+	p, code := openPanel(opts)
+	if p == nil {
+		return code
+	}
+	defer p.Disconnect()
+
+	zones := p.GetZones()
+	if !*all {
+		zones = usedZones(zones)
+	}
+
+	if opts.json {
+		payloads := make([]map[string]interface{}, 0, len(zones))
+		for _, zone := range zones {
+			payloads = append(payloads, mqtt.ZonePayload(zone))
+		}
+		return printJSON(payloads)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ZONE\tID\tNAME\tTYPE\tSTATUS\tFLAGS")
+	for _, zone := range zones {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", zone.Number, zone.ID, zone.Name,
+			types.ZoneTypeDescriptions[zone.Type], types.ZoneStateDescriptions[zone.Status], zoneFlags(zone.Flags))
+	}
+	w.Flush()
+	return exitOK
+}
+
+func zoneFlags(flags types.ZoneFlags) string {
+	var set []string
+	if flags.Fault {
+		set = append(set, "fault")
+	}
+	if flags.FailedTest {
+		set = append(set, "failed test")
+	}
+	if flags.Alarmed {
+		set = append(set, "alarmed")
+	}
+	if flags.ManualBypassed {
+		set = append(set, "bypassed")
+	}
+	if flags.AutoBypassed {
+		set = append(set, "auto bypassed")
+	}
+	if flags.Masked {
+		set = append(set, "masked")
+	}
+	return strings.Join(set, ", ")
+}
+
+func listAreas(args []string) int {
+	flags, opts := newControlFlags("areas")
+	if len(parseFlags(flags, args)) > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: texecom2mqtt areas [flags]")
+		return exitUsage
+	}
+
+	p, code := openPanel(opts)
+	if p == nil {
+		return code
+	}
+	defer p.Disconnect()
+
+	areas := p.GetAreas()
+	if opts.json {
+		payloads := make([]map[string]interface{}, 0, len(areas))
+		for _, area := range areas {
+			payloads = append(payloads, mqtt.AreaPayload(area))
+		}
+		return printJSON(payloads)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "AREA\tID\tNAME\tSTATUS\tFLAGS")
+	for _, area := range areas {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", area.Number, area.ID, area.Name, types.GetAreaStatus(area), areaFlags(area.Flags))
+	}
+	w.Flush()
+	return exitOK
+}
+
+// areaFlags lists the flags of an area that are set, by their JSON names.
+func areaFlags(flags types.AreaFlags) string {
+	var set []string
+	for name, value := range flags.Fields() {
+		if value {
+			set = append(set, name)
+		}
+	}
+	sort.Strings(set)
+	return strings.Join(set, ", ")
+}
+
+// findArea returns the area named by arg, which may be its number, ID, name
+// or slug.
+func findArea(areas []types.Area, arg string) (types.Area, bool) {
+	number, err := strconv.Atoi(arg)
+	for _, area := range areas {
+		if (err == nil && area.Number == number) ||
+			strings.EqualFold(area.ID, arg) ||
+			strings.EqualFold(area.Name, arg) ||
+			util.Slugify(area.Name) == arg {
+			return area, true
+		}
+	}
+	return types.Area{}, false
+}
+
+var armModes = map[string]types.ArmType{
+	"full":  types.ArmTypeFull,
+	"part1": types.ArmTypePartArm1,
+	"part2": types.ArmTypePartArm2,
+	"part3": types.ArmTypePartArm3,
+}
+
+func arm(args []string) int {
+	flags, opts := newControlFlags("arm")
+	mode := flags.String("mode", "full", "Arm mode: full, part1, part2 or part3")
+	positional := parseFlags(flags, args)
+	armType, ok := armModes[*mode]
+	if len(positional) != 1 || !ok {
+		fmt.Fprintln(os.Stderr, "Usage: texecom2mqtt arm <area> [--mode full|part1|part2|part3] [flags]")
+		return exitUsage
+	}
+
+	return runAreaCommand(opts, positional[0], types.ArmTypeDescriptions[armType], func(p *panel.Panel, area types.Area) error {
+		return p.Arm(area.Number, armType)
+	})
+}
+
+// areaCommand runs the disarm and reset commands.
+func areaCommand(command string, args []string) int {
+	flags, opts := newControlFlags(command)
+	positional := parseFlags(flags, args)
+	if len(positional) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: texecom2mqtt %s <area> [flags]\n", command)
+		return exitUsage
+	}
+
+	description := "Disarm"
+	send := func(p *panel.Panel, area types.Area) error { return p.Disarm(area.Number) }
+	if command == "reset" {
+		description = "Reset"
+		send = func(p *panel.Panel, area types.Area) error { return p.Reset(area.Number) }
+	}
+	return runAreaCommand(opts, positional[0], description, send)
+}
+
+// runAreaCommand sends a command to the area named by arg and reports the
+// result.
+func runAreaCommand(opts *controlOptions, arg, description string, send func(*panel.Panel, types.Area) error) int {
+	p, code := openPanel(opts)
+	if p == nil {
+		return code
+	}
+	defer p.Disconnect()
+
+	area, ok := findArea(p.GetAreas(), arg)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "No area %q on the panel\n", arg)
+		return exitUsage
+	}
+
+	err := send(p, area)
+	if opts.json {
+		result := map[string]interface{}{
+			"area":    mqtt.AreaPayload(area),
+			"command": description,
+			"ok":      err == nil,
+		}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		if code := printJSON(result); code != exitOK {
+			return code
+		}
+	} else if err == nil {
+		fmt.Printf("%s sent to area %s (%s)\n", description, area.ID, area.Name)
+	}
+	if err != nil {
+		if !opts.json {
+			fmt.Fprintf(os.Stderr, "%s of area %s (%s) failed: %v\n", description, area.ID, area.Name, err)
+		}
+		return exitFailure
+	}
+	return exitOK
+}
+
+// monitor prints panel updates as they happen until interrupted. The
+// log-tail command prints log events only.
+func monitor(command string, args []string) int {
+	flags, opts := newControlFlags(command)
+	count := flags.Int("count", 0, "Exit after this many updates, 0 for no limit")
+	if len(parseFlags(flags, args)) > 0 {
+		fmt.Fprintf(os.Stderr, "Usage: texecom2mqtt %s [flags]\n", command)
+		return exitUsage
+	}
+
+	p, code := openPanel(opts)
+	if p == nil {
+		return code
+	}
+	defer p.Disconnect()
+
+	// Updates that arrive faster than they can be printed are dropped, and
+	// the number dropped is reported along with the next update printed.
+	updates := make(chan interface{}, 100)
+	var dropped atomic.Int64
+	p.AddListener(func(update interface{}) {
+		if _, ok := update.(types.LogEvent); command == "log-tail" && !ok {
+			return
+		}
+		select {
+		case updates <- update:
+		default:
+			dropped.Add(1)
+		}
+	})
+	reportDropped := func() {
+		if n := dropped.Swap(0); n > 0 {
+			fmt.Fprintf(os.Stderr, "Dropped %d update(s) that could not be printed in time\n", n)
+		}
+	}
+	defer reportDropped()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	printed := 0
+	for {
+		select {
+		case update := <-updates:
+			reportDropped()
+			if !printUpdate(update, opts.json) {
+				continue
+			}
+			printed++
+			if *count > 0 && printed >= *count {
+				return exitOK
+			}
+		case <-p.Done():
+			fmt.Fprintln(os.Stderr, "Lost connection to panel")
+			return exitUnreachable
+		case <-sigChan:
+			return exitOK
+		}
+	}
+}
+
+// printUpdate prints a panel update on a line of its own, and reports
+// whether it was one worth printing.
+func printUpdate(update interface{}, asJSON bool) bool {
+	now := time.Now()
+	var kind, text string
+	var data interface{}
+	switch u := update.(type) {
+	case types.Area:
+		kind, data = "area", mqtt.AreaPayload(u)
+		text = fmt.Sprintf("area %s (%s): %s", u.ID, u.Name, types.GetAreaStatus(u))
+	case types.Zone:
+		kind, data = "zone", mqtt.ZonePayload(u)
+		text = fmt.Sprintf("zone %d (%s): %s", u.Number, u.Name, types.ZoneStateDescriptions[u.Status])
+		if flags := zoneFlags(u.Flags); flags != "" {
+			text += ", " + flags
+		}
+	case types.LogEvent:
+		kind, data = "log", u
+		text = fmt.Sprintf("log: %s (%s)", u.Description, u.GroupType)
+	case types.PanelChange:
+		kind, data = "layout", u.String()
+		text = fmt.Sprintf("layout changed: %s", u)
+	case types.ConnectionState:
+		kind, data = "connection", u.String()
+		text = fmt.Sprintf("connection: %s", u)
+	default:
+		return false
+	}
+
+	if asJSON {
+		line, err := json.Marshal(map[string]interface{}{"time": now, "type": kind, "data": data})
+		if err != nil {
+			return false
+		}
+		fmt.Println(string(line))
+	} else {
+		fmt.Printf("%s %s\n", now.Format(time.RFC3339), text)
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/daemonp/texecom2mqtt/internal/config"
+	"github.com/daemonp/texecom2mqtt/internal/homeassistant"
+	"github.com/daemonp/texecom2mqtt/internal/log"
+	"github.com/daemonp/texecom2mqtt/internal/panel"
+	"github.com/daemonp/texecom2mqtt/internal/types"
+)
+
+// discover reads the areas and zones of a panel and writes a config.yml
+// listing all of them. It does not connect to MQTT.
+func discover(args []string) int {
+	flags := flag.NewFlagSet("discover", flag.ExitOnError)
+	host := flags.String("host", "", "Panel address, instead of the one in the config file")
+	port := flags.Int("port", 10001, "Panel port, with --host")
+	udlPassword := flags.String("udl-password", "1234", "Panel UDL password, with --host")
+	configFile := flags.String("config", config.DefaultConfigFile(), "Config file to take the panel connection from, without --host")
+	panelName := flags.String("panel", "", "Name or prefix of the panel to read, if the config file has several")
+	output := flags.String("output", "", "File to write the config to, instead of standard output")
+	logLevel := flags.String("log", "error", "Log level")
+	flags.Parse(args)
+
+	var texecomCfg config.TexecomConfig
+	if *host != "" {
+		texecomCfg = config.TexecomConfig{Host: *host, Port: *port, UDLPassword: *udlPassword, RefreshInterval: 3600}
+	} else {
+		cfg, err := config.LoadConfig(*configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			return 1
+		}
+		panelCfg := findPanel(cfg, *panelName)
+		if panelCfg == nil {
+			fmt.Fprintf(os.Stderr, "No panel %q in %s\n", *panelName, *configFile)
+			return 1
+		}
+		texecomCfg = panelCfg.Texecom
+	}
+
+	logger := log.NewLoggerTo(*logLevel, os.Stderr)
+	p := panel.NewPanel(&config.PanelConfig{Texecom: texecomCfg}, logger)
+	defer p.Disconnect()
+
+	if err := p.Connect(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := p.Login(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := p.ReadLayout(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	writeDiscoveredConfig(out, texecomCfg, p.GetDevice(), p.GetAreas(), p.GetZones())
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Wrote %d area(s) and %d zone(s) to %s\n", len(p.GetAreas()), len(p.GetZones()), *output)
+	}
+	return 0
+}
+
+// findPanel returns the panel of cfg with the given name or prefix, or its
+// only panel if name is empty.
+func findPanel(cfg *config.Config, name string) *config.PanelConfig {
+	if name == "" {
+		if len(cfg.Panels) == 1 {
+			return &cfg.Panels[0]
+		}
+		return nil
+	}
+	for i := range cfg.Panels {
+		if cfg.Panels[i].Name == name || cfg.Panels[i].Prefix == name {
+			return &cfg.Panels[i]
+		}
+	}
+	return nil
+}
+
+func writeDiscoveredConfig(w io.Writer, texecomCfg config.TexecomConfig, device types.Device, areas []types.Area, zones []types.Zone) {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Generated by texecom2mqtt discover on %s from\n", time.Now().Format("2006-01-02"))
+	fmt.Fprintf(&b, "# %s, serial number %s, firmware %s.\n", device.Model, device.SerialNumber, device.FirmwareVersion)
+	b.WriteString("# Device classes are guessed from zone names, check them before use.\n\n")
+
+	b.WriteString("texecom:\n")
+	fmt.Fprintf(&b, "  host: %q\n", texecomCfg.Host)
+	// Never write the UDL password itself, the config may be shared
+	if texecomCfg.UDLPasswordFile != "" {
+		fmt.Fprintf(&b, "  udl_password_file: %q\n", texecomCfg.UDLPasswordFile)
+	} else {
+		b.WriteString("  # udl_password: \"<UDL password>\" # or udl_password_file, or TEXECOM2MQTT_TEXECOM_UDL_PASSWORD; defaults to 1234\n")
+	}
+	fmt.Fprintf(&b, "  port: %d\n", texecomCfg.Port)
+	fmt.Fprintf(&b, "  refresh_interval: %d # seconds between re-reading zone/area text; negative disables\n", texecomCfg.RefreshInterval)
+
+	b.WriteString(`
+mqtt:
+  host: "localhost"
+  port: 1883
+  username: ""
+  password: ""
+  client_id: "texecom2mqtt"
+  prefix: "texecom2mqtt"
+  qos: 0
+  retain: true
+  retain_log: false
+
+homeassistant:
+  discovery: true
+  prefix: "homeassistant"
+
+log: "info"
+cache: true
+`)
+
+	b.WriteString("\nareas:\n")
+	for _, area := range areas {
+		fmt.Fprintf(&b, "  - id: %q\n", area.ID)
+		fmt.Fprintf(&b, "    name: %q\n", area.Name)
+		b.WriteString("    full_arm: \"armed_away\"\n")
+		b.WriteString("    part_arm_1: \"armed_home\"\n")
+		b.WriteString("    # code: \"1234\"\n")
+		b.WriteString("    # code_arm_required: false\n")
+		b.WriteString("    # code_disarm_required: true\n")
+	}
+
+	b.WriteString("\nzones:\n")
+	for _, zone := range zones {
+		if zone.Type == types.ZoneTypeNotUsed {
+			fmt.Fprintf(&b, "  - id: %q # not used on the panel\n", zone.ID)
+			b.WriteString("    ignore: true\n")
+			continue
+		}
+		fmt.Fprintf(&b, "  - id: %q # %s\n", zone.ID, zone.Type)
+		fmt.Fprintf(&b, "    name: %q\n", zone.Name)
+		fmt.Fprintf(&b, "    device_class: %q\n", homeassistant.GuessDeviceClass(zone))
+	}
+
+	io.WriteString(w, b.String())
+}
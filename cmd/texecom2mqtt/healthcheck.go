@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/daemonp/texecom2mqtt/internal/config"
+)
+
+// healthcheck queries the health check of a running bridge, for use as a
+// Docker HEALTHCHECK. It exits 0 if the bridge reports healthy and 1 if not.
+func healthcheck(args []string) int {
+	flags := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	configFile := flags.String("config", config.DefaultConfigFile(), "Config file to take http.listen from, without --url")
+	url := flags.String("url", "", "Health check URL, instead of the one from the config file")
+	ready := flags.Bool("ready", false, "Check readiness (/readyz) instead of health (/healthz)")
+	timeout := flags.Duration("timeout", 5*time.Second, "How long to wait for the bridge")
+	flags.Parse(args)
+
+	path := "/healthz"
+	if *ready {
+		path = "/readyz"
+	}
+
+	target := *url
+	if target == "" {
+		cfg, err := config.LoadConfig(*configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			return exitFailure
+		}
+		if cfg.HTTP.Listen == "" {
+			fmt.Fprintf(os.Stderr, "http.listen is not set in %s\n", *configFile)
+			return exitUsage
+		}
+		target = "http://" + localAddress(cfg.HTTP.Listen) + path
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Get(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailure
+	}
+	defer resp.Body.Close()
+
+	io.Copy(os.Stdout, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// localAddress turns a listen address into one to connect to on this host,
+// replacing an empty or unspecified host with the loopback address.
+func localAddress(listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return listen
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -3,113 +3,309 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/daemonp/texecom2mqtt/internal/cache"
 	"github.com/daemonp/texecom2mqtt/internal/config"
 	"github.com/daemonp/texecom2mqtt/internal/homeassistant"
 	"github.com/daemonp/texecom2mqtt/internal/log"
+	"github.com/daemonp/texecom2mqtt/internal/metrics"
 	"github.com/daemonp/texecom2mqtt/internal/mqtt"
 	"github.com/daemonp/texecom2mqtt/internal/panel"
+	"github.com/daemonp/texecom2mqtt/internal/server"
 )
 
+// reconnectDelay is how long to wait before retrying a panel that failed to
+// start or lost its connection.
+const reconnectDelay = 30 * time.Second
+
 func main() {
-	configFile := flag.String("config", "config.yml", "Path to configuration file")
-	flag.Parse()
+	args := os.Args[1:]
+	command := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	os.Exit(runCommand(command, args))
+}
+
+func runCommand(command string, args []string) int {
+	switch command {
+	case "run":
+		return runBridge(args)
+	case "status":
+		return status(args)
+	case "zones":
+		return listZones(args)
+	case "areas":
+		return listAreas(args)
+	case "arm":
+		return arm(args)
+	case "disarm":
+		return areaCommand("disarm", args)
+	case "reset":
+		return areaCommand("reset", args)
+	case "log-tail":
+		return monitor("log-tail", args)
+	case "monitor":
+		return monitor("monitor", args)
+	case "discover":
+		return discover(args)
+	case "config":
+		return configCommand(args)
+	case "ha-cleanup":
+		return haCleanup(args)
+	case "healthcheck":
+		return healthcheck(args)
+	case "help":
+		usage(os.Stdout)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", command)
+		usage(os.Stderr)
+		return exitUsage
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `Usage: texecom2mqtt [command] [flags]
+
+Commands:
+  run          Run the bridge (the default)
+  status       Show the panel and the state of its areas
+  zones        List zones and their state
+  areas        List areas and their state
+  arm          Arm an area
+  disarm       Disarm an area
+  reset        Reset an area after an alarm
+  log-tail     Print panel log events as they happen, not the stored log
+  monitor      Print every area, zone and log event as it happens
+  discover     Generate a config file from a panel
+  config       Validate the config file or print its JSON Schema
+  ha-cleanup   Remove Home Assistant discovery configs of a panel
+  healthcheck  Query the health check of a running bridge
+
+Run texecom2mqtt <command> -h for the flags of a command.
+`)
+}
+
+// runBridge connects every configured panel to MQTT until it is stopped.
+func runBridge(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	configFile := flags.String("config", config.DefaultConfigFile(), "Path to configuration file")
+	flags.Parse(args)
 
 	// Load configuration
 	cfg, err := config.LoadConfig(*configFile)
 	if err != nil {
 		fmt.Printf("Error loading config: %v\n", err)
-		os.Exit(1)
+		return exitFailure
 	}
 
 	// Create logger
 	logger := log.NewLogger(cfg.Log)
+	for _, warning := range cfg.Warnings {
+		logger.Warning("%s", warning)
+	}
+	cache.SetDir(cfg.CacheDir)
 
-	// Create panel
-	p := panel.NewPanel(cfg, logger)
-
-	// Create MQTT client
-	mqttClient := mqtt.NewMQTT(&cfg.MQTT, p, logger)
+	// Create MQTT client shared by all panels
+	mqttClient := mqtt.NewMQTT(&cfg.MQTT, logger)
 
-	// Setup graceful shutdown
+	// Setup graceful shutdown, and config reload on SIGHUP
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	// Connect to panel
-	if err := p.Connect(); err != nil {
-		logger.Error("Failed to connect to panel: %v", err)
-		os.Exit(1)
+	// Connect to MQTT broker
+	if err := mqttClient.Connect(); err != nil {
+		logger.Error("Failed to connect to MQTT broker: %v", err)
+		return exitFailure
 	}
 
-	// Login to panel
-	if err := p.Login(); err != nil {
-		logger.Error("Failed to login to panel: %v", err)
-		p.Disconnect()
-		os.Exit(1)
-	}
-
-	// Load cache if enabled
-	if cfg.Cache {
-		cacheData, err := cache.LoadCache()
-		if err != nil {
-			logger.Warning("Failed to load cache: %v", err)
-		} else if cacheData != nil {
-			// Use cached data to initialize panel
-			p.SetCachedData(cacheData)
-			logger.Info("Loaded data from cache")
+	// Set up every panel
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var runners []*panelRunner
+	for i := range cfg.Panels {
+		panelCfg := &cfg.Panels[i]
+		panelLogger := logger
+		if len(cfg.Panels) > 1 {
+			panelLogger = logger.With("panel", panelCfg.Name)
 		}
-	}
 
-	// Start panel operations
-	if err := p.Start(); err != nil {
-		logger.Error("Failed to start panel operations: %v", err)
-		mqttClient.Close()
-		p.Disconnect()
-		os.Exit(1)
-	}
-
-	// Save cache if enabled
-	if cfg.Cache {
-		device := p.GetDevice()
-		areas := p.GetAreas()
-		zones := p.GetZones()
-		if err := cache.SaveCache(device, areas, zones); err != nil {
-			logger.Warning("Failed to save cache: %v", err)
-		} else {
-			logger.Info("Saved data to cache")
+		p := panel.NewPanel(panelCfg, panelLogger)
+		p.SetObserver(metrics.NewPanelObserver(panelCfg.Name))
+		p.PersistState()
+		if cfg.Cache {
+			p.EnableCache()
+		}
+		r := &panelRunner{
+			haConfig:  cfg.HomeAssistant,
+			panelCfg:  panelCfg,
+			panel:     p,
+			client:    mqttClient.AddPanel(p, panelCfg.Prefix),
+			log:       panelLogger,
+			discovery: cfg.HomeAssistant.Discovery,
 		}
+		runners = append(runners, r)
 	}
-	// Connect to MQTT broker
-	if err := mqttClient.Connect(); err != nil {
-		logger.Error("Failed to connect to MQTT broker: %v", err)
-		p.Disconnect()
-		os.Exit(1)
+
+	// Serve the health checks and metrics
+	var httpServer *server.Server
+	if cfg.HTTP.Listen != "" {
+		panels := make([]server.Panel, 0, len(runners))
+		metricsPanels := make([]metrics.Panel, 0, len(runners))
+		for _, r := range runners {
+			panels = append(panels, r.panel)
+			metricsPanels = append(metricsPanels, r.panel)
+		}
+		httpServer = server.New(&cfg.HTTP, mqttClient, panels, logger)
+		httpServer.Handle("/metrics", metrics.Handler(metricsPanels))
+		if err := httpServer.Start(); err != nil {
+			logger.Error("Failed to start HTTP server: %v", err)
+			mqttClient.Close()
+			return exitFailure
+		}
 	}
 
-	// Initialize and start Home Assistant integration if enabled
-	if cfg.HomeAssistant.Discovery {
-		ha := homeassistant.New(&cfg.HomeAssistant, mqttClient, p, logger)
-		ha.Start()
+	// Run every panel independently so that one failing panel does not
+	// affect the others
+	for _, r := range runners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.run(stop)
+		}()
 	}
 
-	// Wait for termination signal
-	<-sigChan
+	// Wait for termination signal, reloading the config on SIGHUP
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reload(*configFile, cfg, mqttClient, runners, logger)
+	}
 
 	// Graceful shutdown
 	logger.Info("Shutting down...")
+	close(stop)
+	for _, r := range runners {
+		r.panel.Disconnect()
+	}
+	wg.Wait()
+	if httpServer != nil {
+		httpServer.Close()
+	}
 	mqttClient.Close()
-	p.Disconnect()
+	return exitOK
+}
+
+// panelRunner keeps a single panel connected and published.
+type panelRunner struct {
+	// haConfig is the runner's own copy of the homeassistant settings, so
+	// that a reload never writes to the config Home Assistant reads
+	haConfig config.HomeAssistantConfig
+	// panelCfg is changed by reload once new overrides have been applied
+	panelCfg *config.PanelConfig
+	panel    *panel.Panel
+	client   *mqtt.PanelClient
+	log      *log.Logger
+	ha       *homeassistant.HomeAssistant
+	started  bool
+	// discovery is homeassistant.discovery as last loaded, which a reload
+	// can change while the panel runs
+	discovery bool
+	mu        sync.Mutex
+}
+
+// run keeps the panel connected until stop is closed, restarting it after
+// reconnectDelay whenever it fails to start or drops its connection.
+func (r *panelRunner) run(stop <-chan struct{}) {
+	// Publish the last known state while the panel is being reached
+	if r.panel.RestoreState() {
+		r.client.PublishRestoredState()
+	}
 
-	// Delete cache if enabled
-	if cfg.Cache {
-		if err := cache.DeleteCache(); err != nil {
-			logger.Warning("Failed to delete cache: %v", err)
+	for {
+		if err := startPanel(r.panel); err != nil {
+			r.log.Error("Failed to start panel %s: %v", r.panelCfg.Name, err)
+			r.panel.Disconnect()
+			r.client.SetPanelOffline()
 		} else {
-			logger.Info("Deleted cache")
+			r.client.Start()
+
+			r.mu.Lock()
+			r.started = true
+			r.mu.Unlock()
+			r.startHomeAssistant()
+
+			select {
+			case <-r.panel.Done():
+				r.log.Warning("Lost connection to panel %s", r.panelCfg.Name)
+				r.panel.Disconnect()
+				r.client.SetPanelOffline()
+			case <-stop:
+				return
+			}
+		}
+
+		r.log.Info("Reconnecting to panel %s in %s", r.panelCfg.Name, reconnectDelay)
+		select {
+		case <-time.After(reconnectDelay):
+		case <-stop:
+			return
 		}
+		metrics.PanelReconnects.WithLabelValues(r.panelCfg.Name).Inc()
+	}
+}
+
+// startHomeAssistant initializes and starts the Home Assistant integration,
+// once, if discovery is enabled and the panel has been published.
+func (r *panelRunner) startHomeAssistant() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started || r.ha != nil || !r.discovery {
+		return
+	}
+	r.ha = homeassistant.New(&r.haConfig, r.client, r.panel, r.log)
+	r.ha.Start()
+}
+
+// reloadHomeAssistant brings Home Assistant discovery in line with a
+// reloaded config.
+func (r *panelRunner) reloadHomeAssistant(discovery bool) {
+	r.mu.Lock()
+	r.discovery = discovery
+	ha := r.ha
+	r.mu.Unlock()
+
+	if ha == nil {
+		r.startHomeAssistant()
+		return
+	}
+	ha.Reload(discovery)
+}
+
+func startPanel(p *panel.Panel) error {
+	// Connect to panel
+	if err := p.Connect(); err != nil {
+		return err
+	}
+
+	// Login to panel
+	if err := p.Login(); err != nil {
+		return err
+	}
+
+	// Start panel operations
+	if err := p.Start(); err != nil {
+		return err
 	}
+
+	return nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/daemonp/texecom2mqtt/internal/config"
+	"github.com/daemonp/texecom2mqtt/internal/log"
+	"github.com/daemonp/texecom2mqtt/internal/mqtt"
+)
+
+// reload loads the config file again and applies the changes that do not
+// need a restart to the running config cfg. Changes that do are logged and
+// left for the next restart. An invalid config is not applied at all.
+func reload(configFile string, cfg *config.Config, mqttClient *mqtt.MQTT, runners []*panelRunner, logger *log.Logger) {
+	logger.Info("Reloading config from %s", configFile)
+
+	newCfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		logger.Error("Not reloading config: %v", err)
+		return
+	}
+	for _, warning := range newCfg.Warnings {
+		logger.Warning("%s", warning)
+	}
+
+	changes := config.Diff(cfg, newCfg)
+	for _, setting := range changes.Restart {
+		logger.Warning("Change to %s requires a restart, not applied", setting)
+	}
+	if len(changes.Live) == 0 {
+		logger.Info("No changes to apply")
+		return
+	}
+
+	if changes.Has("log") {
+		cfg.Log = newCfg.Log
+		if err := log.SetLevel(cfg.Log); err != nil {
+			logger.Error("Failed to set log level: %v", err)
+		}
+	}
+
+	if changes.Has("mqtt") {
+		if err := mqttClient.Reconfigure(&newCfg.MQTT); err != nil {
+			logger.Error("Not applying MQTT topic changes: %v", err)
+		}
+	}
+
+	// Only reload reads cfg once the panels run, each runner has its own
+	// copy of the settings it uses
+	cfg.HomeAssistant.Discovery = newCfg.HomeAssistant.Discovery
+
+	for _, r := range runners {
+		reloadOverrides(r, newCfg)
+		r.reloadHomeAssistant(newCfg.HomeAssistant.Discovery)
+	}
+
+	logger.Info("Reloaded config, applied changes to %s", strings.Join(changes.Live, ", "))
+}
+
+// reloadOverrides applies the area and zone overrides that newCfg gives the
+// panel of r, which is matched by name.
+func reloadOverrides(r *panelRunner, newCfg *config.Config) {
+	var newPanel *config.PanelConfig
+	for i := range newCfg.Panels {
+		if newCfg.Panels[i].Name == r.panelCfg.Name {
+			newPanel = &newCfg.Panels[i]
+		}
+	}
+	if newPanel == nil {
+		r.log.Warning("Panel %s is no longer in the config, not applying area and zone overrides until restarted", r.panelCfg.Name)
+		return
+	}
+	if reflect.DeepEqual(r.panelCfg.Areas, newPanel.Areas) && reflect.DeepEqual(r.panelCfg.Zones, newPanel.Zones) {
+		return
+	}
+
+	if err := r.panel.SetOverrides(newPanel.Areas, newPanel.Zones); err != nil {
+		r.log.Error("Failed to apply area and zone overrides: %v", err)
+		return
+	}
+	r.panelCfg.Areas = newPanel.Areas
+	r.panelCfg.Zones = newPanel.Zones
+}
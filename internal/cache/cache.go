@@ -5,15 +5,35 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/daemonp/texecom2mqtt/internal/types"
+	"github.com/daemonp/texecom2mqtt/internal/util"
 )
 
-const cacheFileName = "texecom2mqtt_cache.json"
+// Version is the version of the cache file format. Cache files of any other
+// version are ignored.
+const Version = 1
 
-func SaveCache(device types.Device, areas []types.Area, zones []types.Zone) error {
+var (
+	dir   string
+	dirMu sync.Mutex
+)
+
+// SetDir sets the directory holding the cache and other persisted state. An
+// empty dir selects the default, ~/.cache/texecom2mqtt.
+func SetDir(d string) {
+	dirMu.Lock()
+	defer dirMu.Unlock()
+	dir = d
+}
+
+// SaveCache stores the area and zone text of the panel with the given serial
+// number.
+func SaveCache(serial string, device types.Device, areas []types.Area, zones []types.Zone) error {
 	cacheData := types.CacheData{
+		Version:    Version,
 		Device:     device,
 		Areas:      areas,
 		Zones:      zones,
@@ -25,7 +45,7 @@ func SaveCache(device types.Device, areas []types.Area, zones []types.Zone) erro
 		return fmt.Errorf("failed to marshal cache data: %v", err)
 	}
 
-	cacheDir, err := getCacheDir()
+	cacheDir, err := Dir()
 	if err != nil {
 		return fmt.Errorf("failed to get cache directory: %v", err)
 	}
@@ -35,22 +55,27 @@ func SaveCache(device types.Device, areas []types.Area, zones []types.Zone) erro
 		return fmt.Errorf("failed to create cache directory: %v", err)
 	}
 
-	cacheFilePath := filepath.Join(cacheDir, cacheFileName)
-	err = os.WriteFile(cacheFilePath, data, 0644)
-	if err != nil {
+	cacheFilePath := filepath.Join(cacheDir, cacheFile(serial))
+	tmp := cacheFilePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
 		return fmt.Errorf("failed to write cache file: %v", err)
 	}
+	if err := os.Rename(tmp, cacheFilePath); err != nil {
+		return fmt.Errorf("failed to replace cache file: %v", err)
+	}
 
 	return nil
 }
 
-func LoadCache() (*types.CacheData, error) {
-	cacheDir, err := getCacheDir()
+// LoadCache returns the cached data of the panel with the given serial
+// number, or nil if there is none.
+func LoadCache(serial string) (*types.CacheData, error) {
+	cacheDir, err := Dir()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get cache directory: %v", err)
 	}
 
-	cacheFilePath := filepath.Join(cacheDir, cacheFileName)
+	cacheFilePath := filepath.Join(cacheDir, cacheFile(serial))
 	data, err := os.ReadFile(cacheFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -68,13 +93,32 @@ func LoadCache() (*types.CacheData, error) {
 	return &cacheData, nil
 }
 
-func DeleteCache() error {
-	cacheDir, err := getCacheDir()
+// Validate checks that data was cached for device as it is now. A firmware
+// upgrade or a change in zone count can change the area and zone layout, so
+// either invalidates the cache.
+func Validate(data *types.CacheData, device types.Device) error {
+	switch {
+	case data.Version != Version:
+		return fmt.Errorf("cache version %d, expected %d", data.Version, Version)
+	case data.Device.SerialNumber != device.SerialNumber:
+		return fmt.Errorf("cached for panel %s", data.Device.SerialNumber)
+	case data.Device.FirmwareVersion != device.FirmwareVersion:
+		return fmt.Errorf("cached for firmware %s, panel runs %s", data.Device.FirmwareVersion, device.FirmwareVersion)
+	case device.Zones > 0 && len(data.Zones) != device.Zones:
+		return fmt.Errorf("%d zones cached, panel has %d", len(data.Zones), device.Zones)
+	case len(data.Areas) == 0 || len(data.Zones) == 0:
+		return fmt.Errorf("no areas or zones cached")
+	}
+	return nil
+}
+
+func DeleteCache(serial string) error {
+	cacheDir, err := Dir()
 	if err != nil {
 		return fmt.Errorf("failed to get cache directory: %v", err)
 	}
 
-	cacheFilePath := filepath.Join(cacheDir, cacheFileName)
+	cacheFilePath := filepath.Join(cacheDir, cacheFile(serial))
 	err = os.Remove(cacheFilePath)
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to delete cache file: %v", err)
@@ -83,6 +127,23 @@ func DeleteCache() error {
 	return nil
 }
 
+// cacheFile returns the cache file name for the panel with the given serial
+// number.
+func cacheFile(serial string) string {
+	return fmt.Sprintf("panel_%s.json", util.Slugify(serial))
+}
+
+// Dir returns the directory holding the cache and other persisted state.
+func Dir() (string, error) {
+	dirMu.Lock()
+	d := dir
+	dirMu.Unlock()
+	if d != "" {
+		return d, nil
+	}
+	return getCacheDir()
+}
+
 func getCacheDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -91,3 +152,67 @@ func getCacheDir() (string, error) {
 
 	return filepath.Join(homeDir, ".cache", "texecom2mqtt"), nil
 }
+
+// SaveState stores the last known state of the panel with the given name,
+// replacing the previous state atomically.
+func SaveState(name string, state *types.PanelState) error {
+	state.Version = Version
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %v", err)
+	}
+
+	cacheDir, err := Dir()
+	if err != nil {
+		return fmt.Errorf("failed to get cache directory: %v", err)
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	path := filepath.Join(cacheDir, stateFile(name))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace state file: %v", err)
+	}
+	return nil
+}
+
+// LoadState returns the last known state of the panel with the given name,
+// or nil if there is none or it was saved by another version.
+func LoadState(name string) (*types.PanelState, error) {
+	cacheDir, err := Dir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache directory: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cacheDir, stateFile(name)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+
+	var state types.PanelState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state: %v", err)
+	}
+	if state.Version != Version {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+// stateFile returns the state file name for the panel with the given name.
+// State is keyed by the panel's MQTT prefix rather than its serial number,
+// as it is needed before the panel has been reached.
+func stateFile(name string) string {
+	if name == "" {
+		return "state.json"
+	}
+	return fmt.Sprintf("state_%s.json", util.Slugify(name))
+}
@@ -3,42 +3,118 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
-
-	"gopkg.in/yaml.v2"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
 )
 
 type Config struct {
 	Texecom       TexecomConfig       `yaml:"texecom"`
+	Panels        []PanelConfig       `yaml:"panels"`
 	MQTT          MQTTConfig          `yaml:"mqtt"`
 	HomeAssistant HomeAssistantConfig `yaml:"homeassistant"`
 	Zones         []ZoneConfig        `yaml:"zones"`
 	Areas         []AreaConfig        `yaml:"areas"`
 	Log           string              `yaml:"log"`
 	Cache         bool                `yaml:"cache"`
+	// CacheDir holds the cache and other persisted state. Defaults to
+	// ~/.cache/texecom2mqtt.
+	CacheDir string     `yaml:"cache_dir"`
+	HTTP     HTTPConfig `yaml:"http"`
+	// Warnings are problems found while loading the config that did not stop
+	// it from loading, for the caller to log.
+	Warnings []string `yaml:"-"`
+}
+
+// HTTPConfig configures the optional local HTTP listener that serves the
+// health checks and metrics.
+type HTTPConfig struct {
+	// Listen is the address to listen on, such as "127.0.0.1:8080". Nothing
+	// is served when it is empty.
+	Listen string `yaml:"listen"`
+	// MaxMessageAge is how long, in seconds, a panel may go without sending
+	// anything before it is reported unhealthy. Defaults to 120.
+	MaxMessageAge int `yaml:"max_message_age"`
 }
 
 type TexecomConfig struct {
 	Host        string `yaml:"host"`
 	UDLPassword string `yaml:"udl_password"`
-	Port        int    `yaml:"port"`
+	// UDLPasswordFile is read for the UDL password when it is not set.
+	UDLPasswordFile string `yaml:"udl_password_file"`
+	Port            int    `yaml:"port"`
+	// RefreshInterval is how often, in seconds, area and zone text and types
+	// are re-read to pick up changes made with Wintex. Defaults to an hour;
+	// a negative value disables it.
+	RefreshInterval int `yaml:"refresh_interval"`
+	// ReadOnly stops the bridge from sending the panel any command that could
+	// change its state, for sites where it may only be observed.
+	ReadOnly bool `yaml:"read_only"`
+}
+
+// PanelConfig describes one panel served by the bridge. Prefix is appended to
+// the MQTT prefix so that every panel publishes below its own topic tree.
+type PanelConfig struct {
+	Name    string        `yaml:"name"`
+	Prefix  string        `yaml:"prefix"`
+	Texecom TexecomConfig `yaml:"texecom"`
+	Zones   []ZoneConfig  `yaml:"zones"`
+	Areas   []AreaConfig  `yaml:"areas"`
+}
+
+// Topic naming schemes for area and zone topics.
+const (
+	TopicSchemeSlug     = "slug"
+	TopicSchemeNumber   = "number"
+	TopicSchemeID       = "id"
+	TopicSchemeTemplate = "template"
+)
+
+// Payload formats for state topics.
+const (
+	PayloadJSON   = "json"
+	PayloadString = "string"
+	PayloadFields = "fields"
+)
+
+// PayloadConfig selects the payload format of each class of state topic:
+// a JSON object, the plain status string, or one retained subtopic per
+// field of the JSON object.
+type PayloadConfig struct {
+	Area string `yaml:"area"`
+	Zone string `yaml:"zone"`
+	Log  string `yaml:"log"`
+}
+
+// OutboxConfig controls the on-disk queue of non-retained messages that
+// could not be published while the broker was unreachable.
+type OutboxConfig struct {
+	Path        string `yaml:"path"`
+	MaxMessages int    `yaml:"max_messages"`
 }
 
 type MQTTConfig struct {
-	ClientID           string `yaml:"client_id"`
-	Host               string `yaml:"host"`
-	Port               int    `yaml:"port"`
-	Keepalive          int    `yaml:"keepalive"`
-	Password           string `yaml:"password"`
-	QOS                int    `yaml:"qos"`
-	Retain             bool   `yaml:"retain"`
-	RetainLog          bool   `yaml:"retain_log"`
-	Username           string `yaml:"username"`
-	CA                 string `yaml:"ca"`
-	Cert               string `yaml:"cert"`
-	Key                string `yaml:"key"`
-	RejectUnauthorized bool   `yaml:"reject_unauthorized"`
-	Prefix             string `yaml:"prefix"`
-	Clean              bool   `yaml:"clean"`
+	ClientID           string        `yaml:"client_id"`
+	Host               string        `yaml:"host"`
+	Port               int           `yaml:"port"`
+	Keepalive          int           `yaml:"keepalive"`
+	Password           string        `yaml:"password"`
+	PasswordFile       string        `yaml:"password_file"`
+	QOS                int           `yaml:"qos"`
+	Retain             bool          `yaml:"retain"`
+	RetainLog          bool          `yaml:"retain_log"`
+	Username           string        `yaml:"username"`
+	CA                 string        `yaml:"ca"`
+	Cert               string        `yaml:"cert"`
+	Key                string        `yaml:"key"`
+	RejectUnauthorized bool          `yaml:"reject_unauthorized"`
+	Prefix             string        `yaml:"prefix"`
+	Clean              bool          `yaml:"clean"`
+	TopicScheme        string        `yaml:"topic_scheme"`
+	TopicTemplate      string        `yaml:"topic_template"`
+	Payload            PayloadConfig `yaml:"payload"`
+	Outbox             OutboxConfig  `yaml:"outbox"`
 }
 
 type HomeAssistantConfig struct {
@@ -50,30 +126,82 @@ type ZoneConfig struct {
 	ID          string `yaml:"id"`
 	Name        string `yaml:"name"`
 	DeviceClass string `yaml:"device_class"`
+	Icon        string `yaml:"icon"`
+	// Hidden zones are published but disabled by default in Home Assistant.
+	Hidden bool `yaml:"hidden"`
+	// Ignored zones are not published to MQTT at all.
+	Ignore bool `yaml:"ignore"`
 }
 
 type AreaConfig struct {
 	ID                 string `yaml:"id"`
 	Name               string `yaml:"name"`
 	Code               string `yaml:"code"`
+	CodeFile           string `yaml:"code_file"`
 	CodeArmRequired    bool   `yaml:"code_arm_required"`
 	CodeDisarmRequired bool   `yaml:"code_disarm_required"`
 	FullArm            string `yaml:"full_arm"`
 	PartArm1           string `yaml:"part_arm_1"`
 	PartArm2           string `yaml:"part_arm_2"`
 	PartArm3           string `yaml:"part_arm_3"`
+	Icon               string `yaml:"icon"`
+	Hidden             bool   `yaml:"hidden"`
+	Ignore             bool   `yaml:"ignore"`
+}
+
+// Area returns the overrides for the area with the given number and ID, or
+// nil if there are none. An override matches on the area ID ("A1"), its
+// number ("1") or its letter ("A").
+func (p *PanelConfig) Area(number int, id string) *AreaConfig {
+	for i := range p.Areas {
+		ref := strings.TrimSpace(p.Areas[i].ID)
+		if strings.EqualFold(ref, id) || ref == strconv.Itoa(number) ||
+			(len(ref) == 1 && strings.EqualFold(ref, string(rune('A'+number-1)))) {
+			return &p.Areas[i]
+		}
+	}
+	return nil
+}
+
+// Zone returns the overrides for the zone with the given number and ID, or
+// nil if there are none. An override matches on the zone ID ("Z1") or its
+// number ("1").
+func (p *PanelConfig) Zone(number int, id string) *ZoneConfig {
+	for i := range p.Zones {
+		ref := strings.TrimSpace(p.Zones[i].ID)
+		if strings.EqualFold(ref, id) || ref == strconv.Itoa(number) {
+			return &p.Zones[i]
+		}
+	}
+	return nil
 }
 
+// LoadConfig reads the config file and applies the environment on top of it.
+// Settings are taken, in order of precedence, from:
+//
+//  1. a TEXECOM2MQTT_* environment variable
+//  2. a TEXECOM2MQTT_*_FILE environment variable, for secrets
+//  3. the config file
+//  4. a *_file setting in the config file, for secrets
+//  5. the built-in default
+//
+// The config file may be missing if settings are given in the environment.
 func LoadConfig(configFile string) (*Config, error) {
-	data, err := ioutil.ReadFile("config.yml")
-	if err != nil {
+	var config Config
+	v := newValidator()
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil && !(os.IsNotExist(err) && hasEnvOverrides()) {
 		return nil, fmt.Errorf("error reading config file: %v", err)
 	}
+	if err == nil {
+		if err := v.decode(data, &config); err != nil {
+			return nil, err
+		}
+	}
 
-	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing config file: %v", err)
+	if err := applyEnv(&config); err != nil {
+		return nil, err
 	}
 
 	// Set default values
@@ -92,17 +220,87 @@ func LoadConfig(configFile string) (*Config, error) {
 	if config.MQTT.Prefix == "" {
 		config.MQTT.Prefix = "texecom2mqtt"
 	}
+	if config.MQTT.Outbox.MaxMessages == 0 {
+		config.MQTT.Outbox.MaxMessages = 1000
+	}
+	if config.HTTP.MaxMessageAge == 0 {
+		config.HTTP.MaxMessageAge = 120
+	}
+	if config.MQTT.TopicScheme == "" {
+		config.MQTT.TopicScheme = TopicSchemeSlug
+	}
+	switch config.MQTT.TopicScheme {
+	case TopicSchemeSlug, TopicSchemeNumber, TopicSchemeID:
+	case TopicSchemeTemplate:
+		if strings.TrimSpace(config.MQTT.TopicTemplate) == "" {
+			v.errorf("mqtt.topic_template", "is required with topic_scheme %q", TopicSchemeTemplate)
+		} else if _, err := template.New("topic").Parse(config.MQTT.TopicTemplate); err != nil {
+			v.errorf("mqtt.topic_template", "invalid template: %v", err)
+		}
+	default:
+		v.errorf("mqtt.topic_scheme", "invalid value %q, expected slug, number, id or template", config.MQTT.TopicScheme)
+	}
+	for _, payload := range []struct {
+		name   string
+		format *string
+	}{
+		{"area", &config.MQTT.Payload.Area},
+		{"zone", &config.MQTT.Payload.Zone},
+		{"log", &config.MQTT.Payload.Log},
+	} {
+		switch *payload.format {
+		case "":
+			*payload.format = PayloadJSON
+		case PayloadJSON, PayloadString, PayloadFields:
+		default:
+			v.errorf("mqtt.payload."+payload.name, "invalid value %q, expected json, string or fields", *payload.format)
+		}
+	}
 	if config.HomeAssistant.Prefix == "" {
 		config.HomeAssistant.Prefix = "homeassistant"
 	}
 	if config.Log == "" {
 		config.Log = "info"
 	}
-	if config.Texecom.UDLPassword == "" {
-		config.Texecom.UDLPassword = "1234"
+
+	if err := readSecrets(&config); err != nil {
+		return nil, err
+	}
+
+	// A config without a panels list describes a single panel using the
+	// top-level texecom, zones and areas sections.
+	panelPath := func(i int) string { return fmt.Sprintf("panels[%d]", i) }
+	if len(config.Panels) == 0 {
+		config.Panels = []PanelConfig{{
+			Texecom: config.Texecom,
+			Zones:   config.Zones,
+			Areas:   config.Areas,
+		}}
+		panelPath = func(int) string { return "" }
+	}
+
+	for i := range config.Panels {
+		panel := &config.Panels[i]
+		if panel.Texecom.UDLPassword == "" {
+			panel.Texecom.UDLPassword = "1234"
+		}
+		if panel.Texecom.Port == 0 {
+			panel.Texecom.Port = 10001
+		}
+		if panel.Texecom.RefreshInterval == 0 {
+			panel.Texecom.RefreshInterval = 3600
+		}
+		if panel.Name == "" {
+			panel.Name = panel.Prefix
+		}
+		if panel.Name == "" {
+			panel.Name = panel.Texecom.Host
+		}
 	}
-	if config.Texecom.Port == 0 {
-		config.Texecom.Port = 10001
+
+	v.validate(&config, panelPath)
+	if err := v.err(); err != nil {
+		return nil, err
 	}
 
 	return &config, nil
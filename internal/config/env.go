@@ -0,0 +1,217 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the name of every environment variable that overrides a
+// setting. The rest of the name is the path of YAML keys to the setting,
+// upper-cased and joined with underscores, with list entries addressed by
+// their index: TEXECOM2MQTT_MQTT_HOST, TEXECOM2MQTT_AREAS_0_CODE or
+// TEXECOM2MQTT_PANELS_1_TEXECOM_HOST.
+const EnvPrefix = "TEXECOM2MQTT_"
+
+// EnvConfigFile names the config file when --config is not given.
+const EnvConfigFile = EnvPrefix + "CONFIG"
+
+const fileSuffix = "_file"
+
+// DefaultConfigFile is the config file used when --config is not given.
+func DefaultConfigFile() string {
+	if path := os.Getenv(EnvConfigFile); path != "" {
+		return path
+	}
+	return "config.yml"
+}
+
+// hasEnvOverrides reports whether any setting is overridden by the
+// environment.
+func hasEnvOverrides() bool {
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, EnvPrefix) && !strings.HasPrefix(env, EnvConfigFile+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEnv overrides settings of config from TEXECOM2MQTT_* environment
+// variables. Variables naming a *_file setting are applied first and clear
+// the setting they stand in for, so that a secret file given in the
+// environment wins over a value in the config file, and a value given in the
+// environment wins over both. Variables that name no setting are added to the
+// warnings of config.
+func applyEnv(config *Config) error {
+	var names []string
+	values := make(map[string]string)
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, EnvPrefix) || name == EnvConfigFile {
+			continue
+		}
+		names = append(names, name)
+		values[name] = value
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return isFileVar(names[i]) && !isFileVar(names[j])
+	})
+
+	for _, name := range names {
+		ok, err := setPath(reflect.ValueOf(config).Elem(), strings.TrimPrefix(name, EnvPrefix), values[name])
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		if !ok {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("ignoring unknown environment variable %s", name))
+		}
+	}
+	return nil
+}
+
+func isFileVar(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), fileSuffix)
+}
+
+// setPath sets the setting below v named by path, reporting whether path
+// names a setting.
+func setPath(v reflect.Value, path, value string) (bool, error) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		// Try longer keys first so that PASSWORD_FILE is not taken for
+		// PASSWORD followed by FILE
+		fields := make([]int, t.NumField())
+		for i := range fields {
+			fields[i] = i
+		}
+		sort.Slice(fields, func(i, j int) bool {
+			return len(yamlKey(t.Field(fields[i]))) > len(yamlKey(t.Field(fields[j])))
+		})
+
+		for _, i := range fields {
+			key := strings.ToUpper(yamlKey(t.Field(i)))
+			if key == "" {
+				continue
+			}
+			if path == key {
+				if err := setValue(v.Field(i), value); err != nil {
+					return true, err
+				}
+				clearSecret(v, t.Field(i))
+				return true, nil
+			}
+			if rest, ok := strings.CutPrefix(path, key+"_"); ok {
+				if found, err := setPath(v.Field(i), rest, value); found || err != nil {
+					return found, err
+				}
+			}
+		}
+		return false, nil
+	case reflect.Slice:
+		index, rest, ok := strings.Cut(path, "_")
+		if !ok {
+			return false, nil
+		}
+		n, err := strconv.Atoi(index)
+		if err != nil || n < 0 {
+			return false, nil
+		}
+		if n >= v.Len() {
+			grown := reflect.MakeSlice(v.Type(), n+1, n+1)
+			reflect.Copy(grown, v)
+			v.Set(grown)
+		}
+		return setPath(v.Index(n), rest, value)
+	}
+	return false, nil
+}
+
+// clearSecret clears the setting that a *_file setting stands in for.
+func clearSecret(v reflect.Value, field reflect.StructField) {
+	key, ok := strings.CutSuffix(yamlKey(field), fileSuffix)
+	if !ok {
+		return
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if yamlKey(v.Type().Field(i)) == key {
+			v.Field(i).Set(reflect.Zero(v.Field(i).Type()))
+		}
+	}
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", value)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", value)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
+
+func yamlKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	return key
+}
+
+// readSecrets replaces every empty secret that has a *_file setting with the
+// contents of that file.
+func readSecrets(config *Config) error {
+	if err := readSecret(&config.Texecom.UDLPassword, config.Texecom.UDLPasswordFile, "texecom udl_password_file"); err != nil {
+		return err
+	}
+	if err := readSecret(&config.MQTT.Password, config.MQTT.PasswordFile, "mqtt password_file"); err != nil {
+		return err
+	}
+	if err := readAreaCodes(config.Areas, "areas"); err != nil {
+		return err
+	}
+	for i := range config.Panels {
+		panel := &config.Panels[i]
+		if err := readSecret(&panel.Texecom.UDLPassword, panel.Texecom.UDLPasswordFile, fmt.Sprintf("panels[%d] texecom udl_password_file", i)); err != nil {
+			return err
+		}
+		if err := readAreaCodes(panel.Areas, fmt.Sprintf("panels[%d] areas", i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readAreaCodes(areas []AreaConfig, name string) error {
+	for i := range areas {
+		if err := readSecret(&areas[i].Code, areas[i].CodeFile, fmt.Sprintf("%s[%d] code_file", name, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSecret(secret *string, path, name string) error {
+	if *secret != "" || path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", name, err)
+	}
+	*secret = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
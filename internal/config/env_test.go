@@ -0,0 +1,174 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `texecom:
+  host: 192.168.1.100
+  udl_password: "1111"
+mqtt:
+  host: file-broker
+  password: file-password
+`
+
+// writeFile writes data to name in a temporary directory and returns its
+// path.
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	t.Setenv("TEXECOM2MQTT_MQTT_HOST", "env-broker")
+	t.Setenv("TEXECOM2MQTT_TEXECOM_PORT", "10002")
+
+	cfg, err := LoadConfig(writeFile(t, "config.yml", testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MQTT.Host != "env-broker" {
+		t.Errorf("mqtt host = %q, want env-broker", cfg.MQTT.Host)
+	}
+	if port := cfg.Panels[0].Texecom.Port; port != 10002 {
+		t.Errorf("texecom port = %d, want 10002", port)
+	}
+	if cfg.Panels[0].Texecom.Host != "192.168.1.100" {
+		t.Errorf("texecom host = %q, want the one from the file", cfg.Panels[0].Texecom.Host)
+	}
+}
+
+func TestEnvFileOverridesFile(t *testing.T) {
+	t.Setenv("TEXECOM2MQTT_MQTT_PASSWORD_FILE", writeFile(t, "password", "env-secret\n"))
+
+	cfg, err := LoadConfig(writeFile(t, "config.yml", testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MQTT.Password != "env-secret" {
+		t.Errorf("mqtt password = %q, want env-secret", cfg.MQTT.Password)
+	}
+}
+
+func TestEnvOverridesEnvFile(t *testing.T) {
+	t.Setenv("TEXECOM2MQTT_MQTT_PASSWORD_FILE", writeFile(t, "password", "env-secret\n"))
+	t.Setenv("TEXECOM2MQTT_MQTT_PASSWORD", "env-password")
+
+	cfg, err := LoadConfig(writeFile(t, "config.yml", testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MQTT.Password != "env-password" {
+		t.Errorf("mqtt password = %q, want env-password", cfg.MQTT.Password)
+	}
+}
+
+func TestFileSettings(t *testing.T) {
+	udl := writeFile(t, "udl", "2222\r\n")
+	code := writeFile(t, "code", "5678\n\n")
+	cfg, err := LoadConfig(writeFile(t, "config.yml", `texecom:
+  host: 192.168.1.100
+  udl_password_file: `+udl+`
+mqtt:
+  password: file-password
+  password_file: `+udl+`
+areas:
+  - id: A1
+    code_file: `+code+`
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Panels[0].Texecom.UDLPassword; got != "2222" {
+		t.Errorf("udl password = %q, want 2222", got)
+	}
+	if got := cfg.Panels[0].Areas[0].Code; got != "5678" {
+		t.Errorf("area code = %q, want 5678", got)
+	}
+	if cfg.MQTT.Password != "file-password" {
+		t.Errorf("mqtt password = %q, want the one set next to password_file", cfg.MQTT.Password)
+	}
+}
+
+func TestMissingSecretFile(t *testing.T) {
+	t.Setenv("TEXECOM2MQTT_TEXECOM_UDL_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	_, err := LoadConfig(writeFile(t, "config.yml", testConfig))
+	if err == nil || !strings.Contains(err.Error(), "udl_password_file") {
+		t.Errorf("err = %v, want an error reading udl_password_file", err)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	path := writeFile(t, "bridge.yml", testConfig)
+
+	t.Setenv(EnvConfigFile, "")
+	if got := DefaultConfigFile(); got != "config.yml" {
+		t.Errorf("default config file = %q, want config.yml", got)
+	}
+
+	t.Setenv(EnvConfigFile, path)
+	if got := DefaultConfigFile(); got != path {
+		t.Errorf("default config file = %q, want %q", got, path)
+	}
+	cfg, err := LoadConfig(DefaultConfigFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MQTT.Host != "file-broker" {
+		t.Errorf("mqtt host = %q, want file-broker", cfg.MQTT.Host)
+	}
+	if len(cfg.Warnings) > 0 {
+		t.Errorf("warnings = %q, want none for %s", cfg.Warnings, EnvConfigFile)
+	}
+
+	// --config wins over the environment
+	t.Setenv(EnvConfigFile, filepath.Join(t.TempDir(), "missing.yml"))
+	if _, err := LoadConfig(path); err != nil {
+		t.Errorf("loading --config file: %v", err)
+	}
+}
+
+func TestMissingConfigFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "config.yml")
+	if _, err := LoadConfig(missing); err == nil {
+		t.Error("loaded a missing config file without environment overrides")
+	}
+
+	t.Setenv("TEXECOM2MQTT_TEXECOM_HOST", "192.168.1.100")
+	cfg, err := LoadConfig(missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Panels[0].Texecom.Host != "192.168.1.100" {
+		t.Errorf("texecom host = %q, want 192.168.1.100", cfg.Panels[0].Texecom.Host)
+	}
+}
+
+func TestUnknownEnvWarns(t *testing.T) {
+	t.Setenv("TEXECOM2MQTT_MQTT_HOSTNAME", "broker")
+
+	cfg, err := LoadConfig(writeFile(t, "config.yml", testConfig))
+	if err != nil {
+		t.Fatalf("unknown variable failed the config: %v", err)
+	}
+	if len(cfg.Warnings) != 1 || !strings.Contains(cfg.Warnings[0], "TEXECOM2MQTT_MQTT_HOSTNAME") {
+		t.Errorf("warnings = %q, want one naming TEXECOM2MQTT_MQTT_HOSTNAME", cfg.Warnings)
+	}
+}
+
+func TestInvalidEnv(t *testing.T) {
+	t.Setenv("TEXECOM2MQTT_MQTT_PORT", "broker")
+
+	_, err := LoadConfig(writeFile(t, "config.yml", testConfig))
+	if err == nil || !strings.Contains(err.Error(), "TEXECOM2MQTT_MQTT_PORT") {
+		t.Errorf("err = %v, want an error naming TEXECOM2MQTT_MQTT_PORT", err)
+	}
+}
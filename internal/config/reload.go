@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// liveSettings are the settings that a reload applies without restarting.
+// Settings of each panel are matched without their panels[n] prefix.
+var liveSettings = []string{
+	"log",
+	"mqtt.qos",
+	"mqtt.retain",
+	"mqtt.retain_log",
+	"mqtt.topic_scheme",
+	"mqtt.topic_template",
+	"mqtt.payload",
+	"homeassistant.discovery",
+	"zones",
+	"areas",
+}
+
+// Changes lists the settings that differ between two configs, split into
+// those a reload can apply and those that need a restart.
+type Changes struct {
+	Live    []string
+	Restart []string
+}
+
+func (c Changes) Empty() bool {
+	return len(c.Live) == 0 && len(c.Restart) == 0
+}
+
+// Has reports whether setting, or a setting below it, is among the live
+// changes.
+func (c Changes) Has(setting string) bool {
+	for _, s := range c.Live {
+		if s == setting || strings.HasPrefix(s, setting+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff compares the loaded configs old and new.
+func Diff(old, new *Config) Changes {
+	var paths []string
+	oldValue, newValue := reflect.ValueOf(*old), reflect.ValueOf(*new)
+	t := oldValue.Type()
+	for i := 0; i < t.NumField(); i++ {
+		switch key := yamlKey(t.Field(i)); key {
+		case "panels":
+			paths = append(paths, diffPanels(old.Panels, new.Panels)...)
+		case "texecom", "zones", "areas":
+			// Compared as part of the panels they were copied to
+		case "-":
+			// Not a setting
+		default:
+			paths = append(paths, diffValues(oldValue.Field(i), newValue.Field(i), key)...)
+		}
+	}
+
+	var changes Changes
+	for _, path := range paths {
+		if strings.HasSuffix(path, fileSuffix) {
+			// Only the secret read from the file matters
+			continue
+		}
+		if isLive(path) {
+			changes.Live = append(changes.Live, path)
+		} else {
+			changes.Restart = append(changes.Restart, path)
+		}
+	}
+	return changes
+}
+
+func diffPanels(old, new []PanelConfig) []string {
+	if len(old) != len(new) {
+		return []string{"panels"}
+	}
+	var paths []string
+	for i := range old {
+		// The panel of a config without a panels list is reported by its
+		// top-level settings
+		path := ""
+		if len(old) > 1 || old[i].Prefix != "" || new[i].Prefix != "" {
+			path = fmt.Sprintf("panels[%d]", i)
+		}
+		paths = append(paths, diffValues(reflect.ValueOf(old[i]), reflect.ValueOf(new[i]), path)...)
+	}
+	return paths
+}
+
+// diffValues returns the paths of the settings that differ between a and b.
+// Lists are compared as a whole.
+func diffValues(a, b reflect.Value, path string) []string {
+	if a.Kind() != reflect.Struct {
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			return nil
+		}
+		return []string{path}
+	}
+
+	var paths []string
+	for i := 0; i < a.NumField(); i++ {
+		paths = append(paths, diffValues(a.Field(i), b.Field(i), join(path, yamlKey(a.Type().Field(i))))...)
+	}
+	return paths
+}
+
+func isLive(path string) bool {
+	if strings.HasPrefix(path, "panels[") {
+		if _, rest, ok := strings.Cut(path, "]."); ok {
+			path = rest
+		}
+	}
+	for _, setting := range liveSettings {
+		if path == setting || strings.HasPrefix(path, setting+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// schemaEnums are the allowed values of settings with a fixed set of values,
+// by path. List entries are matched with [] in place of their index.
+var schemaEnums = map[string][]interface{}{
+	"log":                  enum(LogLevels),
+	"mqtt.qos":             {0, 1, 2},
+	"mqtt.topic_scheme":    enum([]string{TopicSchemeSlug, TopicSchemeNumber, TopicSchemeID, TopicSchemeTemplate}),
+	"mqtt.payload.area":    enum(payloadFormats),
+	"mqtt.payload.zone":    enum(payloadFormats),
+	"mqtt.payload.log":     enum(payloadFormats),
+	"zones[].device_class": enum(DeviceClasses),
+	"areas[].full_arm":     enum(ArmModes),
+	"areas[].part_arm_1":   enum(ArmModes),
+	"areas[].part_arm_2":   enum(ArmModes),
+	"areas[].part_arm_3":   enum(ArmModes),
+}
+
+var payloadFormats = []string{PayloadJSON, PayloadString, PayloadFields}
+
+func enum(values []string) []interface{} {
+	e := make([]interface{}, len(values))
+	for i, v := range values {
+		e[i] = v
+	}
+	return e
+}
+
+// schemaPorts are the settings holding a TCP port.
+var schemaPorts = []string{"texecom.port", "mqtt.port"}
+
+// Schema returns a JSON Schema of the config file, generated from the Config
+// struct so that it cannot fall behind the settings the bridge accepts.
+func Schema() ([]byte, error) {
+	schema := schemaFor(reflect.TypeOf(Config{}), "")
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "texecom2mqtt configuration"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func schemaFor(t reflect.Type, path string) map[string]interface{} {
+	schema := make(map[string]interface{})
+
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			key := yamlKey(t.Field(i))
+			if key == "" || key == "-" {
+				continue
+			}
+			properties[key] = schemaFor(t.Field(i).Type, join(path, key))
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+	case reflect.Slice:
+		schema["type"] = "array"
+		schema["items"] = schemaFor(t.Elem(), path+"[]")
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Int:
+		schema["type"] = "integer"
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	}
+
+	// Panels share the texecom, areas and zones settings of the top level
+	generic := strings.TrimPrefix(path, "panels[].")
+	if values := schemaEnums[generic]; values != nil {
+		schema["enum"] = values
+	}
+	for _, port := range schemaPorts {
+		if generic == port {
+			schema["minimum"] = 1
+			schema["maximum"] = 65535
+		}
+	}
+	return schema
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaIsCommitted(t *testing.T) {
+	schema, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := os.ReadFile("../../config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(schema), bytes.TrimSpace(committed)) {
+		t.Error("config.schema.json is out of date, run make schema")
+	}
+}
+
+func TestSchemaPathsExist(t *testing.T) {
+	var paths []string
+	for path := range schemaEnums {
+		paths = append(paths, path)
+	}
+	paths = append(paths, schemaPorts...)
+
+	for _, path := range paths {
+		if !settingExists(reflect.TypeOf(Config{}), path) {
+			t.Errorf("%s is not a setting", path)
+		}
+	}
+}
+
+// settingExists reports whether path, with [] in place of list indexes,
+// names a setting below t.
+func settingExists(t reflect.Type, path string) bool {
+	key, rest, more := strings.Cut(path, ".")
+	key, list := strings.CutSuffix(key, "[]")
+
+	for i := 0; i < t.NumField(); i++ {
+		if yamlKey(t.Field(i)) != key {
+			continue
+		}
+		field := t.Field(i).Type
+		if list {
+			if field.Kind() != reflect.Slice {
+				return false
+			}
+			field = field.Elem()
+		}
+		if !more {
+			return field.Kind() != reflect.Struct && field.Kind() != reflect.Slice
+		}
+		return field.Kind() == reflect.Struct && settingExists(field, rest)
+	}
+	return false
+}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ArmModes are the arm modes of a Home Assistant alarm_control_panel that an
+// area arm type can be mapped to.
+var ArmModes = []string{"armed_away", "armed_home", "armed_night", "armed_vacation", "armed_custom_bypass"}
+
+// DeviceClasses are the Home Assistant binary_sensor device classes a zone
+// can use.
+var DeviceClasses = []string{
+	"battery", "battery_charging", "carbon_monoxide", "cold", "connectivity",
+	"door", "garage_door", "gas", "heat", "light", "lock", "moisture",
+	"motion", "moving", "occupancy", "opening", "plug", "power", "presence",
+	"problem", "running", "safety", "smoke", "sound", "tamper", "update",
+	"vibration", "window",
+}
+
+// LogLevels are the accepted values of the log setting.
+var LogLevels = []string{"trace", "debug", "info", "warn", "warning", "error"}
+
+// ValidationError is a single problem with the config. Line is the line of
+// the config file the problem was found on, or 0 if the setting did not come
+// from the file.
+type ValidationError struct {
+	Line    int
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors is every problem found with the config.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid config:\n  %s", strings.Join(lines, "\n  "))
+}
+
+// validator collects validation errors, locating settings in the config
+// file by their path.
+type validator struct {
+	lines map[string]int
+	errs  ValidationErrors
+}
+
+func newValidator() *validator {
+	return &validator{lines: make(map[string]int)}
+}
+
+func (v *validator) errorf(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{
+		Line:    v.line(path),
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// line returns the line of the setting at path, or of its closest parent
+// found in the file.
+func (v *validator) line(path string) int {
+	for path != "" {
+		if line, ok := v.lines[path]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+// err returns the collected errors sorted by line, or nil if there are none.
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool { return v.errs[i].Line < v.errs[j].Line })
+	return v.errs
+}
+
+// decode decodes the config file into config, recording the line of every
+// setting and reporting keys that config has no setting for.
+func (v *validator) decode(data []byte, config *Config) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("error parsing config file: %v", err)
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+
+	v.walk(root.Content[0], reflect.TypeOf(*config), "")
+
+	if err := root.Content[0].Decode(config); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, msg := range typeErr.Errors {
+				v.errs = append(v.errs, v.typeError(msg))
+			}
+			return nil
+		}
+		return fmt.Errorf("error parsing config file: %v", err)
+	}
+	return nil
+}
+
+// typeError turns a yaml.v3 "line N: message" error into a ValidationError
+// for the setting on that line.
+func (v *validator) typeError(msg string) ValidationError {
+	err := ValidationError{Path: "config", Message: msg}
+	if rest, ok := strings.CutPrefix(msg, "line "); ok {
+		if n, message, ok := strings.Cut(rest, ": "); ok {
+			if line, convErr := strconv.Atoi(n); convErr == nil {
+				err.Line, err.Message = line, message
+			}
+		}
+	}
+	for path, line := range v.lines {
+		if line == err.Line && path != "" && (err.Path == "config" || len(path) > len(err.Path)) {
+			err.Path = path
+		}
+	}
+	return err
+}
+
+func (v *validator) walk(node *yaml.Node, t reflect.Type, path string) {
+	v.lines[path] = node.Line
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		keys := make(map[string]bool)
+		for i := 0; i < t.NumField(); i++ {
+			keys[yamlKey(t.Field(i))] = true
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			child := key.Value
+			if path != "" {
+				child = path + "." + key.Value
+			}
+			if !keys[key.Value] {
+				v.lines[child] = key.Line
+				v.errorf(child, "unknown setting%s", suggest(key.Value, keys))
+				continue
+			}
+			field, _ := fieldByKey(t, key.Value)
+			v.walk(value, field.Type, child)
+			v.lines[child] = key.Line
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			v.walk(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if yamlKey(t.Field(i)) == key {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// suggest returns a hint naming the known key closest to key, if any is
+// close enough to be a likely typo.
+func suggest(key string, keys map[string]bool) string {
+	best, bestDistance := "", 3
+	for known := range keys {
+		if d := distance(key, known); d < bestDistance || (d == bestDistance && known < best) {
+			best, bestDistance = known, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// validate checks the values of config once defaults have been applied.
+// panelPath returns the path of a panel's settings, which are at the top
+// level for a config without a panels list.
+func (v *validator) validate(config *Config, panelPath func(i int) string) {
+	if config.MQTT.QOS < 0 || config.MQTT.QOS > 2 {
+		v.errorf("mqtt.qos", "must be 0, 1 or 2, got %d", config.MQTT.QOS)
+	}
+	v.checkPort("mqtt.port", config.MQTT.Port)
+	if config.MQTT.Outbox.MaxMessages < 0 {
+		v.errorf("mqtt.outbox.max_messages", "must not be negative, got %d", config.MQTT.Outbox.MaxMessages)
+	}
+	v.checkOneOf("log", config.Log, LogLevels)
+	if config.HTTP.Listen != "" {
+		if _, _, err := net.SplitHostPort(config.HTTP.Listen); err != nil {
+			v.errorf("http.listen", "must be host:port or :port, got %q", config.HTTP.Listen)
+		}
+	}
+	if config.HTTP.MaxMessageAge < 0 {
+		v.errorf("http.max_message_age", "must not be negative, got %d", config.HTTP.MaxMessageAge)
+	}
+
+	prefixes := make(map[string]bool)
+	for i := range config.Panels {
+		panel := &config.Panels[i]
+		path := panelPath(i)
+
+		v.checkPort(join(path, "texecom.port"), panel.Texecom.Port)
+		if len(config.Panels) > 1 && panel.Prefix == "" {
+			v.errorf(join(path, "prefix"), "panel %d (%s) needs a prefix when more than one panel is configured", i+1, panel.Name)
+		}
+		if prefixes[panel.Prefix] {
+			v.errorf(join(path, "prefix"), "panel %d (%s) uses duplicate prefix %q", i+1, panel.Name, panel.Prefix)
+		}
+		prefixes[panel.Prefix] = true
+
+		v.validateZones(panel.Zones, join(path, "zones"))
+		v.validateAreas(panel.Areas, join(path, "areas"))
+	}
+}
+
+func (v *validator) validateZones(zones []ZoneConfig, path string) {
+	seen := make(map[string]int)
+	for i, zone := range zones {
+		zonePath := fmt.Sprintf("%s[%d]", path, i)
+		ref := zoneRef(zone.ID)
+		if ref == "" {
+			v.errorf(zonePath+".id", "is required")
+		} else if first, ok := seen[ref]; ok {
+			v.errorf(zonePath+".id", "zone %q is already configured by %s[%d]", zone.ID, path, first)
+		} else {
+			seen[ref] = i
+		}
+		if zone.DeviceClass != "" {
+			v.checkOneOf(zonePath+".device_class", zone.DeviceClass, DeviceClasses)
+		}
+	}
+}
+
+func (v *validator) validateAreas(areas []AreaConfig, path string) {
+	seen := make(map[string]int)
+	for i, area := range areas {
+		areaPath := fmt.Sprintf("%s[%d]", path, i)
+		ref := areaRef(area.ID)
+		if ref == "" {
+			v.errorf(areaPath+".id", "is required")
+		} else if first, ok := seen[ref]; ok {
+			v.errorf(areaPath+".id", "area %q is already configured by %s[%d]", area.ID, path, first)
+		} else {
+			seen[ref] = i
+		}
+		for key, mode := range map[string]string{
+			"full_arm":   area.FullArm,
+			"part_arm_1": area.PartArm1,
+			"part_arm_2": area.PartArm2,
+			"part_arm_3": area.PartArm3,
+		} {
+			if mode != "" {
+				v.checkOneOf(areaPath+"."+key, mode, ArmModes)
+			}
+		}
+	}
+}
+
+func (v *validator) checkPort(path string, port int) {
+	if port < 1 || port > 65535 {
+		v.errorf(path, "must be between 1 and 65535, got %d", port)
+	}
+}
+
+func (v *validator) checkOneOf(path, value string, allowed []string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.errorf(path, "invalid value %q, expected one of %s", value, strings.Join(allowed, ", "))
+}
+
+// zoneRef normalizes a zone reference, so that "Z1", "z01" and "1" compare
+// equal.
+func zoneRef(id string) string {
+	ref := strings.ToUpper(strings.TrimSpace(id))
+	if n, err := strconv.Atoi(strings.TrimPrefix(ref, "Z")); err == nil {
+		return strconv.Itoa(n)
+	}
+	return ref
+}
+
+// areaRef normalizes an area reference, so that "A1", "1" and "A" compare
+// equal.
+func areaRef(id string) string {
+	ref := strings.ToUpper(strings.TrimSpace(id))
+	if len(ref) == 1 && ref[0] >= 'A' && ref[0] <= 'Z' {
+		return strconv.Itoa(int(ref[0]-'A') + 1)
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(ref, "A")); err == nil {
+		return strconv.Itoa(n)
+	}
+	return ref
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// loadErrors loads data as a config file and returns the validation errors
+// it fails with.
+func loadErrors(t *testing.T, data string) ValidationErrors {
+	t.Helper()
+	_, err := LoadConfig(writeFile(t, "config.yml", data))
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("LoadConfig() error = %v, want validation errors", err)
+	}
+	return errs
+}
+
+func TestUnknownSettingSuggestsKey(t *testing.T) {
+	errs := loadErrors(t, testConfig+"  prefx: alarm\n")
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1: %v", len(errs), errs)
+	}
+	err := errs[0]
+	if err.Path != "mqtt.prefx" || err.Line != 7 {
+		t.Errorf("error at %s line %d, want mqtt.prefx line 7", err.Path, err.Line)
+	}
+	if !strings.Contains(err.Message, `"prefix"`) {
+		t.Errorf("message %q does not suggest prefix", err.Message)
+	}
+}
+
+func TestUnknownSettingWithoutSuggestion(t *testing.T) {
+	errs := loadErrors(t, testConfig+"  something_else: true\n")
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1: %v", len(errs), errs)
+	}
+	if errs[0].Message != "unknown setting" {
+		t.Errorf("message = %q, want no suggestion", errs[0].Message)
+	}
+}
+
+func TestBadTypeReportsLine(t *testing.T) {
+	errs := loadErrors(t, `texecom:
+  host: 192.168.1.100
+  port: not-a-number
+`)
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1: %v", len(errs), errs)
+	}
+	if err := errs[0]; err.Path != "texecom.port" || err.Line != 3 {
+		t.Errorf("error at %s line %d, want texecom.port line 3", err.Path, err.Line)
+	}
+}
+
+func TestReportsEveryError(t *testing.T) {
+	errs := loadErrors(t, `texecom:
+  host: 192.168.1.100
+  hots: typo
+mqtt:
+  port: 70000
+  topic_scheme: numbers
+log: loud
+`)
+	want := []struct {
+		path string
+		line int
+	}{
+		{"texecom.hots", 3},
+		{"mqtt.port", 5},
+		{"mqtt.topic_scheme", 6},
+		{"log", 7},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d: %v", len(errs), len(want), errs)
+	}
+	for i, w := range want {
+		if errs[i].Path != w.path || errs[i].Line != w.line {
+			t.Errorf("error %d at %s line %d, want %s line %d", i, errs[i].Path, errs[i].Line, w.path, w.line)
+		}
+	}
+}
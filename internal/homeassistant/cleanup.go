@@ -0,0 +1,59 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/daemonp/texecom2mqtt/internal/config"
+	"github.com/daemonp/texecom2mqtt/internal/mqtt"
+	"github.com/daemonp/texecom2mqtt/internal/util"
+)
+
+// Cleanup removes every discovery config that belongs to the panel with the
+// given serial number, whether or not this bridge published it, and forgets
+// the panel's discovery registry. It returns the removed config topics.
+func Cleanup(mqttCfg *config.MQTTConfig, haCfg *config.HomeAssistantConfig, serial string, wait time.Duration) ([]string, error) {
+	deviceID := fmt.Sprintf("texecom_%s", util.Slugify(serial))
+	filters := []string{
+		fmt.Sprintf("%s/+/+/config", haCfg.Prefix),
+		fmt.Sprintf("%s/+/+/+/config", haCfg.Prefix),
+	}
+
+	topics, err := mqtt.ClearRetained(mqttCfg, filters, wait, func(topic string, payload []byte) bool {
+		return belongsTo(payload, deviceID)
+	})
+	if err != nil {
+		return topics, err
+	}
+
+	if err := DeleteRegistry(util.Slugify(serial)); err != nil {
+		return topics, err
+	}
+	return topics, nil
+}
+
+// belongsTo reports whether the discovery config payload is for an entity of
+// the device with the given ID.
+func belongsTo(payload []byte, deviceID string) bool {
+	var config struct {
+		UniqueID string `json:"unique_id"`
+		Device   struct {
+			Identifiers []string `json:"identifiers"`
+		} `json:"device"`
+	}
+	if err := json.Unmarshal(payload, &config); err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(config.UniqueID, deviceID+"_") {
+		return true
+	}
+	for _, id := range config.Device.Identifiers {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package homeassistant
+
+import (
+	"fmt"
+
+	"github.com/daemonp/texecom2mqtt/internal/config"
+	"github.com/daemonp/texecom2mqtt/internal/types"
+	"github.com/daemonp/texecom2mqtt/internal/util"
+)
+
+// publishControlsConfig publishes the panel-wide controls: a button that
+// syncs the panel clock and a text entity for the LCD display. Read only
+// panels have none.
+func (ha *HomeAssistant) publishControlsConfig() {
+	if ha.panel.ReadOnly() {
+		return
+	}
+
+	ha.publishConfig("button", "sync_time", "", map[string]interface{}{
+		"name":            "Sync Time",
+		"unique_id":       fmt.Sprintf("texecom_%s_sync_time", ha.serial()),
+		"command_topic":   ha.mqtt.Topics().DateTime(),
+		"payload_press":   "now",
+		"icon":            "mdi:clock-check-outline",
+		"entity_category": "config",
+	})
+
+	ha.publishConfig("text", "lcd_text", "", map[string]interface{}{
+		"name":          "LCD Text",
+		"unique_id":     fmt.Sprintf("texecom_%s_lcd_text", ha.serial()),
+		"command_topic": ha.mqtt.Topics().Text(),
+		"max":           32,
+		"icon":          "mdi:message-text-outline",
+	})
+}
+
+// publishAreaResetConfig publishes a button that resets the area.
+func (ha *HomeAssistant) publishAreaResetConfig(area types.Area) {
+	config := map[string]interface{}{
+		"name":          fmt.Sprintf("%s Reset", area.Name),
+		"unique_id":     fmt.Sprintf("texecom_%s_area_%d_reset", ha.serial(), area.Number),
+		"command_topic": ha.mqtt.Topics().AreaCommand(area),
+		"payload_press": "reset",
+		"icon":          "mdi:restore",
+	}
+	if area.HomeAssistant != nil && area.HomeAssistant.Hidden {
+		config["enabled_by_default"] = false
+	}
+
+	ha.publishConfig("button", area.ID+"_reset", "", config)
+}
+
+// publishDiagnosticsConfig publishes the diagnostic sensors of the panel and
+// of the bridge.
+func (ha *HomeAssistant) publishDiagnosticsConfig() {
+	topics := ha.mqtt.Topics()
+
+	logTopic, logTemplate := stateSource(ha.mqtt.PayloadFormats().Log, topics.Log(), "description")
+	lastEvent := map[string]interface{}{
+		"name":            "Last Log Event",
+		"unique_id":       fmt.Sprintf("texecom_%s_last_log_event", ha.serial()),
+		"state_topic":     logTopic,
+		"value_template":  logTemplate,
+		"icon":            "mdi:format-list-bulleted",
+		"entity_category": "diagnostic",
+	}
+	if ha.mqtt.PayloadFormats().Log == config.PayloadJSON {
+		lastEvent["json_attributes_topic"] = logTopic
+	}
+	ha.publishConfig("sensor", "last_log_event", "", lastEvent)
+
+	ha.publishConfig("sensor", "firmware", "", map[string]interface{}{
+		"name":            "Firmware",
+		"unique_id":       fmt.Sprintf("texecom_%s_firmware", ha.serial()),
+		"state_topic":     topics.Config(),
+		"value_template":  "{{ value_json.firmware_version }}",
+		"icon":            "mdi:chip",
+		"entity_category": "diagnostic",
+	})
+
+	states := make([]string, 0, len(types.ConnectionStateDescriptions))
+	for state := types.ConnectionStateDisconnected; state <= types.ConnectionStateReady; state++ {
+		states = append(states, state.String())
+	}
+	ha.publishConfig("sensor", "connection", "enum", map[string]interface{}{
+		"name":            "Connection",
+		"unique_id":       fmt.Sprintf("texecom_%s_connection", ha.serial()),
+		"state_topic":     topics.Connection(),
+		"options":         states,
+		"icon":            "mdi:lan-connect",
+		"entity_category": "diagnostic",
+		"availability": []map[string]string{
+			{"topic": ha.mqtt.BridgeTopics().Status()},
+		},
+	})
+
+	ha.publishBridgeSensorConfig("uptime", "Uptime", map[string]interface{}{
+		"value_template":      "{{ value_json.uptime }}",
+		"device_class":        "duration",
+		"unit_of_measurement": "s",
+		"state_class":         "total_increasing",
+	})
+	ha.publishBridgeSensorConfig("outbox_size", "Outbox Size", map[string]interface{}{
+		"value_template": "{{ value_json.outbox_size }}",
+		"icon":           "mdi:tray-full",
+		"state_class":    "measurement",
+	})
+}
+
+// publishBridgeSensorConfig publishes a diagnostic sensor of the bridge
+// device reading from the bridge diagnostics topic.
+func (ha *HomeAssistant) publishBridgeSensorConfig(objectId, name string, config map[string]interface{}) {
+	bridge := ha.mqtt.BridgeTopics()
+	config["name"] = name
+	config["unique_id"] = fmt.Sprintf("%s_%s", ha.bridgeID(), objectId)
+	config["state_topic"] = bridge.Diagnostics()
+	config["entity_category"] = "diagnostic"
+	config["device"] = ha.bridgeDevice()
+	config["availability_topic"] = bridge.Status()
+
+	topic := fmt.Sprintf("%s/sensor/%s/%s/config", ha.config.Prefix, util.Slugify(bridge.Prefix()), objectId)
+	ha.registry.add(topic, nil)
+	ha.publishPayload(topic, config)
+}
@@ -0,0 +1,85 @@
+package homeassistant
+
+import (
+	"fmt"
+
+	"github.com/daemonp/texecom2mqtt/internal/util"
+)
+
+const (
+	onlinePayload  = "online"
+	offlinePayload = "offline"
+)
+
+// device is the device block shared by every entity of the panel. It is
+// linked to the bridge device through via_device.
+func (ha *HomeAssistant) device() map[string]interface{} {
+	device := ha.panel.GetDevice()
+	return map[string]interface{}{
+		"identifiers":   []string{ha.deviceID()},
+		"name":          ha.deviceName(),
+		"manufacturer":  "Texecom",
+		"model":         device.Model,
+		"sw_version":    device.FirmwareVersion,
+		"serial_number": device.SerialNumber,
+		"via_device":    ha.bridgeID(),
+	}
+}
+
+func (ha *HomeAssistant) deviceID() string {
+	return fmt.Sprintf("texecom_%s", ha.serial())
+}
+
+func (ha *HomeAssistant) deviceName() string {
+	if name := ha.panel.Name(); name != "" && ha.mqtt.GetPrefix() != ha.mqtt.BridgeTopics().Prefix() {
+		return fmt.Sprintf("Texecom %s", name)
+	}
+	return fmt.Sprintf("Texecom %s", ha.panel.GetDevice().Model)
+}
+
+// bridgeDevice is the device representing the texecom2mqtt process itself.
+func (ha *HomeAssistant) bridgeDevice() map[string]interface{} {
+	return map[string]interface{}{
+		"identifiers":  []string{ha.bridgeID()},
+		"name":         "texecom2mqtt",
+		"manufacturer": "texecom2mqtt",
+		"model":        "Bridge",
+	}
+}
+
+func (ha *HomeAssistant) bridgeID() string {
+	return fmt.Sprintf("texecom2mqtt_%s", util.Slugify(ha.mqtt.BridgeTopics().Prefix()))
+}
+
+// availability makes an entity unavailable when either the bridge or the
+// panel connection is down.
+func (ha *HomeAssistant) availability() map[string]interface{} {
+	return map[string]interface{}{
+		"availability": []map[string]string{
+			{"topic": ha.mqtt.BridgeTopics().Status()},
+			{"topic": ha.mqtt.Topics().PanelStatus()},
+		},
+		"availability_mode":     "all",
+		"payload_available":     onlinePayload,
+		"payload_not_available": offlinePayload,
+	}
+}
+
+// publishBridgeConfig publishes a connectivity sensor for the bridge so that
+// the bridge device exists for via_device to refer to.
+func (ha *HomeAssistant) publishBridgeConfig() {
+	config := map[string]interface{}{
+		"name":            "Bridge",
+		"unique_id":       fmt.Sprintf("%s_status", ha.bridgeID()),
+		"state_topic":     ha.mqtt.BridgeTopics().Status(),
+		"payload_on":      onlinePayload,
+		"payload_off":     offlinePayload,
+		"device_class":    "connectivity",
+		"entity_category": "diagnostic",
+		"device":          ha.bridgeDevice(),
+	}
+
+	topic := fmt.Sprintf("%s/binary_sensor/%s/bridge/config", ha.config.Prefix, util.Slugify(ha.mqtt.BridgeTopics().Prefix()))
+	ha.registry.add(topic, nil)
+	ha.publishPayload(topic, config)
+}
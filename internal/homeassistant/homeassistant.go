@@ -3,6 +3,9 @@ package homeassistant
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
 
 	"github.com/daemonp/texecom2mqtt/internal/config"
 	"github.com/daemonp/texecom2mqtt/internal/log"
@@ -13,10 +16,13 @@ import (
 )
 
 type HomeAssistant struct {
-	config *config.HomeAssistantConfig
-	mqtt   mqtt.MQTTClient
-	panel  *panel.Panel
-	log    *log.Logger
+	config   *config.HomeAssistantConfig
+	mqtt     mqtt.MQTTClient
+	panel    *panel.Panel
+	log      *log.Logger
+	registry *registry
+	// discovery starts as config.Discovery and is changed by Reload
+	discovery atomic.Bool
 }
 
 type MQTTClient interface {
@@ -26,21 +32,86 @@ type MQTTClient interface {
 }
 
 func New(cfg *config.HomeAssistantConfig, mqttClient mqtt.MQTTClient, p *panel.Panel, logger *log.Logger) *HomeAssistant {
-	return &HomeAssistant{
+	ha := &HomeAssistant{
 		config: cfg,
 		mqtt:   mqttClient,
 		panel:  p,
 		log:    logger,
 	}
+	ha.discovery.Store(cfg.Discovery)
+
+	path, err := registryPath(ha.serial())
+	if err != nil {
+		logger.Warning("Not tracking Home Assistant discovery: %v", err)
+	}
+	ha.registry, err = loadRegistry(path)
+	if err != nil {
+		logger.Warning("Failed to load Home Assistant discovery registry: %v", err)
+	}
+
+	p.AddListener(ha.handlePanelUpdate)
+	return ha
 }
 
 func (ha *HomeAssistant) Start() {
 	ha.log.Info("Starting Home Assistant integration")
 	ha.publishDiscoveryConfig()
+	ha.removeStaleConfig()
+
+	ha.mqtt.Subscribe(ha.statusTopic(), ha.handleStatus)
+	ha.mqtt.OnConnect(ha.publishDiscoveryConfig)
+}
+
+// statusTopic is where Home Assistant publishes its birth and last will
+// messages.
+func (ha *HomeAssistant) statusTopic() string {
+	return fmt.Sprintf("%s/status", ha.config.Prefix)
+}
+
+// handleStatus republishes discovery and state when Home Assistant comes
+// online, as its entities are lost on restart if the broker does not persist
+// retained messages.
+func (ha *HomeAssistant) handleStatus(payload string) {
+	if payload != "online" || !ha.discovery.Load() {
+		return
+	}
+
+	ha.log.Info("Home Assistant is online, republishing discovery")
+	go func() {
+		ha.publishDiscoveryConfig()
+		ha.mqtt.PublishState()
+	}()
+}
+
+// Reload publishes discovery again after the config has been reloaded, or
+// removes every entity if discovery has been turned off.
+func (ha *HomeAssistant) Reload(discovery bool) {
+	ha.discovery.Store(discovery)
+	if !discovery {
+		ha.log.Info("Home Assistant discovery disabled, removing entities")
+		for _, topic := range ha.registry.topics() {
+			ha.registry.remove(topic)
+			ha.mqtt.Publish(topic, "", true)
+		}
+		ha.saveRegistry()
+		return
+	}
+
+	ha.log.Info("Republishing Home Assistant discovery")
+	ha.publishDiscoveryConfig()
+	ha.mqtt.PublishState()
 }
 
 func (ha *HomeAssistant) publishDiscoveryConfig() {
+	if !ha.discovery.Load() {
+		return
+	}
+
+	ha.publishBridgeConfig()
 	ha.publishPanelConfig()
+	ha.publishControlsConfig()
+	ha.publishDiagnosticsConfig()
+	ha.publishLogEventConfig()
 
 	for _, area := range ha.panel.GetAreas() {
 		ha.publishAreaConfig(area)
@@ -49,58 +120,313 @@ func (ha *HomeAssistant) publishDiscoveryConfig() {
 	for _, zone := range ha.panel.GetZones() {
 		ha.publishZoneConfig(zone)
 	}
+
+	ha.saveRegistry()
+}
+
+// removeStaleConfig removes the entities that the previous run published but
+// this one did not, clearing their retained state along with them.
+func (ha *HomeAssistant) removeStaleConfig() {
+	configTopics, stateTopics := ha.registry.stale()
+	for _, topic := range configTopics {
+		ha.log.Info("Removing stale Home Assistant entity %s", topic)
+		ha.mqtt.Publish(topic, "", true)
+	}
+	for _, topic := range stateTopics {
+		ha.mqtt.Publish(topic, "", true)
+	}
+}
+
+func (ha *HomeAssistant) saveRegistry() {
+	if ha.registry.path == "" {
+		return
+	}
+	if err := ha.registry.save(); err != nil {
+		ha.log.Warning("Failed to save Home Assistant discovery registry: %v", err)
+	}
+}
+
+func (ha *HomeAssistant) handlePanelUpdate(update interface{}) {
+	change, ok := update.(types.PanelChange)
+	if !ok || !ha.discovery.Load() {
+		return
+	}
+
+	for _, area := range change.RemovedAreas {
+		ha.removeConfig("alarm_control_panel", area.ID)
+		ha.removeConfig("sensor", area.ID)
+		ha.removeConfig("button", area.ID+"_reset")
+	}
+	for _, c := range change.ChangedAreas {
+		ha.publishAreaConfig(c.New)
+	}
+	for _, area := range change.AddedAreas {
+		ha.publishAreaConfig(area)
+	}
+
+	for _, zone := range change.RemovedZones {
+		ha.removeZoneConfig(zone)
+	}
+	for _, c := range change.ChangedZones {
+		ha.publishZoneConfig(c.New)
+	}
+	for _, zone := range change.AddedZones {
+		ha.publishZoneConfig(zone)
+	}
+
+	ha.saveRegistry()
 }
 
+// publishPanelConfig publishes the panel connectivity sensor, which reflects
+// the panel status topic and is only unavailable when the bridge is down.
 func (ha *HomeAssistant) publishPanelConfig() {
-	device := ha.panel.GetDevice()
 	config := map[string]interface{}{
-		"name":         fmt.Sprintf("Texecom %s", device.Model),
-		"identifiers":  []string{device.SerialNumber},
-		"manufacturer": "Texecom",
-		"model":        device.Model,
-		"sw_version":   device.FirmwareVersion,
+		"name":            "Panel",
+		"unique_id":       fmt.Sprintf("texecom_%s_panel", ha.serial()),
+		"state_topic":     ha.mqtt.Topics().PanelStatus(),
+		"payload_on":      onlinePayload,
+		"payload_off":     offlinePayload,
+		"entity_category": "diagnostic",
+		"availability": []map[string]string{
+			{"topic": ha.mqtt.BridgeTopics().Status()},
+		},
 	}
 
 	ha.publishConfig("binary_sensor", "panel", "connectivity", config)
 }
 
 func (ha *HomeAssistant) publishAreaConfig(area types.Area) {
+	if ha.panel.ReadOnly() {
+		ha.publishAreaSensorConfig(area)
+		return
+	}
+
+	format := ha.mqtt.PayloadFormats().Area
+	stateTopic, _ := stateSource(format, ha.mqtt.Topics().Area(area), "status")
+	modes := armModes(area)
+	knownModes := config.ArmModes
 	config := map[string]interface{}{
-		"name":             area.Name,
-		"unique_id":        fmt.Sprintf("%s_area_%s", ha.mqtt.GetPrefix(), util.Slugify(area.Name)),
-		"state_topic":      ha.mqtt.Topics().Area(area),
-		"command_topic":    ha.mqtt.Topics().AreaCommand(area),
-		"payload_disarm":   "disarm",
-		"payload_arm_home": "part_arm_1",
-		"payload_arm_away": "full_arm",
-		"device_class":     "alarm_control_panel",
-		"value_template":   "{{ value_json.status }}",
+		"name":           area.Name,
+		"unique_id":      fmt.Sprintf("texecom_%s_area_%d", ha.serial(), area.Number),
+		"state_topic":    stateTopic,
+		"command_topic":  ha.mqtt.Topics().AreaCommand(area),
+		"payload_disarm": "disarm",
+		"value_template": areaValueTemplate(format, areaStates(modes)),
+	}
+
+	var features []string
+	for _, m := range modes {
+		if !util.Contains(knownModes, m.mode) {
+			ha.log.Warn("Area %s: unknown Home Assistant arm mode %q for %s", area.Name, m.mode, m.command)
+			continue
+		}
+		feature := strings.Replace(m.mode, "armed_", "arm_", 1)
+		config["payload_"+feature] = m.command
+		features = append(features, feature)
+	}
+	config["supported_features"] = util.RemoveDuplicates(features)
+
+	if area.HomeAssistant != nil {
+		applyEntityOverrides(config, area.HomeAssistant.Icon, area.HomeAssistant.Hidden)
+		if area.HomeAssistant.Code != "" {
+			config["code"] = area.HomeAssistant.Code
+		}
+		config["code_arm_required"] = area.HomeAssistant.CodeArmRequired
+		config["code_disarm_required"] = area.HomeAssistant.CodeDisarmRequired
 	}
 
 	ha.publishConfig("alarm_control_panel", area.ID, "", config)
+
+	ha.publishAreaResetConfig(area)
+}
+
+// publishAreaSensorConfig publishes the area of a read only panel, which
+// cannot be armed or disarmed, as an enum sensor of alarm_control_panel
+// states.
+func (ha *HomeAssistant) publishAreaSensorConfig(area types.Area) {
+	format := ha.mqtt.PayloadFormats().Area
+	stateTopic, _ := stateSource(format, ha.mqtt.Topics().Area(area), "status")
+	states := areaStates(armModes(area))
+
+	var options []string
+	for _, state := range states {
+		options = append(options, state)
+	}
+	options = util.RemoveDuplicates(append(options, "disarmed"))
+	sort.Strings(options)
+
+	config := map[string]interface{}{
+		"name":           area.Name,
+		"unique_id":      fmt.Sprintf("texecom_%s_area_%d", ha.serial(), area.Number),
+		"state_topic":    stateTopic,
+		"value_template": areaValueTemplate(format, states),
+		"device_class":   "enum",
+		"options":        options,
+		"icon":           "mdi:shield-home",
+	}
+	if area.HomeAssistant != nil {
+		applyEntityOverrides(config, area.HomeAssistant.Icon, area.HomeAssistant.Hidden)
+	}
+
+	ha.publishConfig("sensor", area.ID, "", config)
 }
 
 func (ha *HomeAssistant) publishZoneConfig(zone types.Zone) {
+	stateTopic, valueTemplate := stateSource(ha.mqtt.PayloadFormats().Zone, ha.mqtt.Topics().Zone(zone), "status")
 	config := map[string]interface{}{
 		"name":           zone.Name,
-		"unique_id":      fmt.Sprintf("%s_zone_%s", ha.mqtt.GetPrefix(), util.Slugify(zone.Name)),
-		"state_topic":    ha.mqtt.Topics().Zone(zone),
+		"unique_id":      fmt.Sprintf("texecom_%s_zone_%d", ha.serial(), zone.Number),
+		"state_topic":    stateTopic,
 		"device_class":   getDeviceClass(zone),
-		"value_template": "{{ value_json.status }}",
+		"value_template": valueTemplate,
 		"payload_on":     "Active",
 		"payload_off":    "Secure",
 	}
+	if zone.HomeAssistant != nil {
+		applyEntityOverrides(config, zone.HomeAssistant.Icon, zone.HomeAssistant.Hidden)
+	}
 
 	ha.publishConfig("binary_sensor", zone.ID, "", config)
+
+	ha.publishZoneTamperConfig(zone)
+	ha.publishZoneProblemConfig(zone)
+	if !ha.panel.ReadOnly() {
+		ha.publishZoneBypassConfig(zone)
+	}
 }
 
-func (ha *HomeAssistant) publishConfig(component, objectId, deviceClass string, config map[string]interface{}) {
-	topic := fmt.Sprintf("%s/%s/%s/%s/config", ha.config.Prefix, component, ha.mqtt.GetPrefix(), objectId)
+// publishZoneTamperConfig publishes a tamper sensor for the zone, disabled
+// by default on tamper zones, which report tamper as their main state.
+func (ha *HomeAssistant) publishZoneTamperConfig(zone types.Zone) {
+	format := ha.mqtt.PayloadFormats().Zone
+	stateTopic, valueTemplate, ok := flagSource(format, ha.mqtt.Topics().Zone(zone), "tamper")
+	if !ok {
+		// The plain string payload only carries the status
+		stateTopic = ha.mqtt.Topics().Zone(zone)
+		valueTemplate = fmt.Sprintf("{{ 'ON' if value == '%s' else 'OFF' }}", types.ZoneStateDescriptions[types.ZoneStateTampered])
+	}
+
+	config := map[string]interface{}{
+		"name":            fmt.Sprintf("%s Tamper", zone.Name),
+		"unique_id":       fmt.Sprintf("texecom_%s_zone_%d_tamper", ha.serial(), zone.Number),
+		"state_topic":     stateTopic,
+		"value_template":  valueTemplate,
+		"entity_category": "diagnostic",
+	}
+	ha.applyZoneEntityDefaults(config, zone, zone.Type != types.ZoneTypeTamper)
+
+	ha.publishConfig("binary_sensor", zone.ID+"_tamper", "tamper", config)
+}
+
+// publishZoneProblemConfig publishes a problem sensor that is on while the
+// zone has a fault, has failed its test or is masked.
+func (ha *HomeAssistant) publishZoneProblemConfig(zone types.Zone) {
+	stateTopic, valueTemplate, ok := flagSource(ha.mqtt.PayloadFormats().Zone, ha.mqtt.Topics().Zone(zone), "problem")
+	if !ok {
+		ha.removeConfig("binary_sensor", zone.ID+"_problem")
+		return
+	}
+
+	config := map[string]interface{}{
+		"name":            fmt.Sprintf("%s Problem", zone.Name),
+		"unique_id":       fmt.Sprintf("texecom_%s_zone_%d_problem", ha.serial(), zone.Number),
+		"state_topic":     stateTopic,
+		"value_template":  valueTemplate,
+		"entity_category": "diagnostic",
+	}
+	ha.applyZoneEntityDefaults(config, zone, true)
+
+	ha.publishConfig("binary_sensor", zone.ID+"_problem", "problem", config)
+}
+
+// publishZoneBypassConfig publishes a switch that bypasses the zone. It is
+// disabled by default on zones that are normally never bypassed.
+func (ha *HomeAssistant) publishZoneBypassConfig(zone types.Zone) {
+	stateTopic, valueTemplate, ok := flagSource(ha.mqtt.PayloadFormats().Zone, ha.mqtt.Topics().Zone(zone), "bypassed")
+	if !ok {
+		ha.removeConfig("switch", zone.ID+"_bypass")
+		return
+	}
+
+	config := map[string]interface{}{
+		"name":            fmt.Sprintf("%s Bypass", zone.Name),
+		"unique_id":       fmt.Sprintf("texecom_%s_zone_%d_bypass", ha.serial(), zone.Number),
+		"state_topic":     stateTopic,
+		"value_template":  valueTemplate,
+		"command_topic":   ha.mqtt.Topics().ZoneBypass(zone),
+		"payload_on":      "ON",
+		"payload_off":     "OFF",
+		"icon":            "mdi:shield-off-outline",
+		"entity_category": "config",
+	}
+	ha.applyZoneEntityDefaults(config, zone, isBypassable(zone.Type))
+
+	ha.publishConfig("switch", zone.ID+"_bypass", "", config)
+}
+
+// applyZoneEntityDefaults disables an extra zone entity by default when it
+// makes no sense for the zone or the zone itself is hidden.
+func (ha *HomeAssistant) applyZoneEntityDefaults(config map[string]interface{}, zone types.Zone, useful bool) {
+	hidden := zone.HomeAssistant != nil && zone.HomeAssistant.Hidden
+	if !useful || hidden || zone.Type == types.ZoneTypeNotUsed {
+		config["enabled_by_default"] = false
+	}
+}
+
+// removeZoneConfig removes every entity published for a zone.
+func (ha *HomeAssistant) removeZoneConfig(zone types.Zone) {
+	ha.removeConfig("binary_sensor", zone.ID)
+	ha.removeConfig("binary_sensor", zone.ID+"_tamper")
+	ha.removeConfig("binary_sensor", zone.ID+"_problem")
+	ha.removeConfig("switch", zone.ID+"_bypass")
+}
+
+func (ha *HomeAssistant) configTopic(component, objectId string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/config", ha.config.Prefix, component, ha.nodeID(), objectId)
+}
 
+// removeConfig publishes an empty retained config, which makes Home Assistant
+// delete the entity.
+func (ha *HomeAssistant) removeConfig(component, objectId string) {
+	topic := ha.configTopic(component, objectId)
+	ha.registry.remove(topic)
+	ha.mqtt.Publish(topic, "", true)
+}
+
+// publishConfig publishes a discovery config for an entity of the panel,
+// adding the panel device and availability unless config sets its own.
+// Device triggers do not support availability.
+func (ha *HomeAssistant) publishConfig(component, objectId, deviceClass string, config map[string]interface{}) {
 	if deviceClass != "" {
 		config["device_class"] = deviceClass
 	}
+	if _, ok := config["device"]; !ok {
+		config["device"] = ha.device()
+	}
+	if _, ok := config["availability"]; !ok && component != "device_automation" {
+		for key, value := range ha.availability() {
+			config[key] = value
+		}
+	}
+
+	topic := ha.configTopic(component, objectId)
+	ha.registry.add(topic, stateTopics(config))
+	ha.publishPayload(topic, config)
+}
+
+// stateTopics returns the retained topics an entity config reads its state
+// from.
+func stateTopics(config map[string]interface{}) []string {
+	var topics []string
+	for _, key := range []string{"state_topic", "json_attributes_topic"} {
+		if topic, ok := config[key].(string); ok && topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
 
+func (ha *HomeAssistant) publishPayload(topic string, config map[string]interface{}) {
 	payload, err := json.Marshal(config)
 	if err != nil {
 		ha.log.Error("Failed to marshal Home Assistant config: %v", err)
@@ -109,3 +435,14 @@ func (ha *HomeAssistant) publishConfig(component, objectId, deviceClass string,
 
 	ha.mqtt.Publish(topic, string(payload), true)
 }
+
+// nodeID is the discovery node ID for this panel. MQTT prefixes of panels
+// with a sub-prefix contain slashes, which Home Assistant does not accept.
+func (ha *HomeAssistant) nodeID() string {
+	return util.Slugify(ha.mqtt.GetPrefix())
+}
+
+// serial identifies the panel across all discovery payloads.
+func (ha *HomeAssistant) serial() string {
+	return util.Slugify(ha.panel.GetDevice().SerialNumber)
+}
@@ -0,0 +1,48 @@
+package homeassistant
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daemonp/texecom2mqtt/internal/mqtt"
+	"github.com/daemonp/texecom2mqtt/internal/types"
+)
+
+// publishLogEventConfig publishes a device trigger and an event entity for
+// every log event group type. Both fire on the group's log topic, whose
+// payload carries the event type and the areas and zone of the event.
+func (ha *HomeAssistant) publishLogEventConfig() {
+	eventTypes := mqtt.LogEventTypes()
+
+	for _, group := range mqtt.LogEventGroups() {
+		topic := ha.mqtt.Topics().LogGroup(group)
+
+		ha.publishConfig("device_automation", "log_"+group.String(), "", map[string]interface{}{
+			"automation_type": "trigger",
+			"topic":           topic,
+			"type":            group.String(),
+			"subtype":         "log_event",
+		})
+
+		config := map[string]interface{}{
+			"name":        fmt.Sprintf("%s Events", groupName(group)),
+			"unique_id":   fmt.Sprintf("texecom_%s_log_%s", ha.serial(), group),
+			"state_topic": topic,
+			"event_types": eventTypes,
+			"icon":        "mdi:alarm-light-outline",
+		}
+		if group == types.LogEventGroupTypeNotReported {
+			config["enabled_by_default"] = false
+		}
+		ha.publishConfig("event", "log_"+group.String(), "", config)
+	}
+}
+
+// groupName turns a group type such as priority_alarm into Priority Alarm.
+func groupName(group types.LogEventGroupType) string {
+	words := strings.Split(group.String(), "_")
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,141 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/daemonp/texecom2mqtt/internal/cache"
+)
+
+// registry remembers which discovery configs were published for a panel,
+// along with the state topics they read, so that entities which disappear
+// between runs can be removed from Home Assistant.
+type registry struct {
+	path     string
+	previous map[string][]string
+	current  map[string][]string
+	mu       sync.Mutex
+}
+
+// registryPath returns the file holding the registry of the panel with the
+// given serial number.
+func registryPath(serial string) (string, error) {
+	dir, err := cache.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("discovery_%s.json", serial)), nil
+}
+
+func loadRegistry(path string) (*registry, error) {
+	r := &registry{
+		path:     path,
+		previous: make(map[string][]string),
+		current:  make(map[string][]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return r, fmt.Errorf("failed to read discovery registry: %v", err)
+	}
+	if err := json.Unmarshal(data, &r.previous); err != nil {
+		return r, fmt.Errorf("failed to unmarshal discovery registry: %v", err)
+	}
+	return r, nil
+}
+
+// add records that the config topic was published, reading stateTopics.
+func (r *registry) add(topic string, stateTopics []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current[topic] = stateTopics
+}
+
+func (r *registry) remove(topic string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.current, topic)
+}
+
+// topics returns the config topics published by this run.
+func (r *registry) topics() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	topics := make([]string, 0, len(r.current))
+	for topic := range r.current {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// stale returns the config topics published by the previous run that have
+// not been published by this one, and those of their state topics that no
+// current entity reads.
+func (r *registry) stale() (configTopics, stateTopics []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	used := make(map[string]bool)
+	for _, topics := range r.current {
+		for _, topic := range topics {
+			used[topic] = true
+		}
+	}
+
+	cleared := make(map[string]bool)
+	for topic, topics := range r.previous {
+		if _, ok := r.current[topic]; ok {
+			continue
+		}
+		configTopics = append(configTopics, topic)
+		for _, stateTopic := range topics {
+			if !used[stateTopic] && !cleared[stateTopic] {
+				cleared[stateTopic] = true
+				stateTopics = append(stateTopics, stateTopic)
+			}
+		}
+	}
+	r.previous = make(map[string][]string)
+	return configTopics, stateTopics
+}
+
+// save writes the current config topics, replacing the file atomically.
+func (r *registry) save() error {
+	r.mu.Lock()
+	data, err := json.Marshal(r.current)
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal discovery registry: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to create discovery registry directory: %v", err)
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write discovery registry: %v", err)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		return fmt.Errorf("failed to replace discovery registry: %v", err)
+	}
+	return nil
+}
+
+// DeleteRegistry removes the discovery registry of the panel with the given
+// serial number.
+func DeleteRegistry(serial string) error {
+	path, err := registryPath(serial)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete discovery registry: %v", err)
+	}
+	return nil
+}
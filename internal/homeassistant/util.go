@@ -1,17 +1,151 @@
 package homeassistant
 
 import (
+	"encoding/json"
+	"fmt"
 	"strings"
 
+	"github.com/daemonp/texecom2mqtt/internal/config"
 	"github.com/daemonp/texecom2mqtt/internal/types"
+	"github.com/daemonp/texecom2mqtt/internal/util"
 )
 
+// stateSource returns the state topic and value template Home Assistant
+// should use to read field from a state topic published in format.
+func stateSource(format, topic, field string) (string, string) {
+	switch format {
+	case config.PayloadString:
+		return topic, "{{ value }}"
+	case config.PayloadFields:
+		return fmt.Sprintf("%s/%s", topic, field), "{{ value }}"
+	default:
+		return topic, fmt.Sprintf("{{ value_json.%s }}", field)
+	}
+}
+
+// armMode maps an area command to the Home Assistant arm mode it performs.
+type armMode struct {
+	command string
+	status  string
+	mode    string
+}
+
+// armModes returns the arm modes an area supports. Unless the area config
+// says otherwise, full arm is armed_away and part arm 1 is armed_home.
+func armModes(area types.Area) []armMode {
+	mapping := types.HomeAssistantArea{}
+	if area.HomeAssistant != nil {
+		mapping = *area.HomeAssistant
+	}
+	if mapping.FullArm == "" {
+		mapping.FullArm = "armed_away"
+	}
+	if mapping.PartArm1 == "" {
+		mapping.PartArm1 = "armed_home"
+	}
+
+	var modes []armMode
+	for _, m := range []armMode{
+		{"full_arm", types.AreaStateDescriptions[types.AreaStateArmed], mapping.FullArm},
+		{"part_arm_1", fmt.Sprintf("%s 1", types.AreaStateDescriptions[types.AreaStatePartArmed]), mapping.PartArm1},
+		{"part_arm_2", fmt.Sprintf("%s 2", types.AreaStateDescriptions[types.AreaStatePartArmed]), mapping.PartArm2},
+		{"part_arm_3", fmt.Sprintf("%s 3", types.AreaStateDescriptions[types.AreaStatePartArmed]), mapping.PartArm3},
+	} {
+		if m.mode != "" {
+			modes = append(modes, m)
+		}
+	}
+	return modes
+}
+
+// areaStates maps every area status string to a Home Assistant
+// alarm_control_panel state.
+func areaStates(modes []armMode) map[string]string {
+	partArmed := types.AreaStateDescriptions[types.AreaStatePartArmed]
+	states := map[string]string{
+		types.AreaStateDescriptions[types.AreaStateDisarmed]: "disarmed",
+		types.AreaStateDescriptions[types.AreaStateInExit]:   "arming",
+		types.AreaStateDescriptions[types.AreaStateInEntry]:  "pending",
+		types.AreaStateDescriptions[types.AreaStateInAlarm]:  "triggered",
+		types.AreaStateDescriptions[types.AreaStateArmed]:    "armed_away",
+		partArmed:        "armed_home",
+		partArmed + " 0": "armed_home",
+		partArmed + " 1": "armed_home",
+		partArmed + " 2": "armed_home",
+		partArmed + " 3": "armed_home",
+	}
+	for _, m := range modes {
+		if util.Contains(config.ArmModes, m.mode) {
+			states[m.status] = m.mode
+		}
+	}
+	// The fields format only publishes the status without the part arm
+	// number, so treat a bare part arm as part arm 1.
+	states[partArmed] = states[partArmed+" 1"]
+	return states
+}
+
+// areaValueTemplate builds a value template translating the area status in
+// the given payload format to a Home Assistant state.
+func areaValueTemplate(format string, states map[string]string) string {
+	mapping, _ := json.Marshal(states)
+
+	if format == config.PayloadJSON {
+		return fmt.Sprintf("{%% set s = value_json.status %%}"+
+			"{%% if value_json.part_arm is defined %%}{%% set s = s ~ ' ' ~ value_json.part_arm %%}{%% endif %%}"+
+			"{{ %s.get(s, 'disarmed') }}", mapping)
+	}
+	return fmt.Sprintf("{{ %s.get(value, 'disarmed') }}", mapping)
+}
+
+// flagSource returns the state topic and a value template yielding ON or OFF
+// for a boolean field of a state topic published in format. The string format
+// carries no flags, so ok is false for it.
+func flagSource(format, topic, field string) (stateTopic, valueTemplate string, ok bool) {
+	switch format {
+	case config.PayloadString:
+		return "", "", false
+	case config.PayloadFields:
+		return fmt.Sprintf("%s/%s", topic, field), "{{ 'ON' if value == 'true' else 'OFF' }}", true
+	default:
+		return topic, fmt.Sprintf("{{ 'ON' if value_json.%s else 'OFF' }}", field), true
+	}
+}
+
+// isBypassable reports whether zones of type t are normally bypassed. Zones
+// that protect life or the system itself are not.
+func isBypassable(t types.ZoneType) bool {
+	switch t {
+	case types.ZoneTypeNotUsed, types.ZoneTypeTwentyFourHourAudible, types.ZoneTypeTwentyFourHourSilent,
+		types.ZoneTypePAAudible, types.ZoneTypePASilent, types.ZoneTypeFire, types.ZoneTypeMedical,
+		types.ZoneTypeTwentyFourHourGas, types.ZoneTypeTamper:
+		return false
+	}
+	return true
+}
+
+// applyEntityOverrides sets the icon of an entity and disables hidden
+// entities by default.
+func applyEntityOverrides(config map[string]interface{}, icon string, hidden bool) {
+	if icon != "" {
+		config["icon"] = icon
+	}
+	if hidden {
+		config["enabled_by_default"] = false
+	}
+}
+
 func getDeviceClass(zone types.Zone) string {
 	// Check if there's a custom device class set in the config
 	if zone.HomeAssistant != nil && zone.HomeAssistant.DeviceClass != "" {
 		return zone.HomeAssistant.DeviceClass
 	}
+	return GuessDeviceClass(zone)
+}
 
+// GuessDeviceClass guesses the binary_sensor device class of a zone from its
+// name.
+func GuessDeviceClass(zone types.Zone) string {
 	// Try to guess the device class based on the zone name
 	name := strings.ToLower(zone.Name)
 	if strings.Contains(name, "pir") {
@@ -2,6 +2,7 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
@@ -14,19 +15,23 @@ type Logger struct {
 }
 
 func NewLogger(level string) *Logger {
-	logLevel, err := zerolog.ParseLevel(strings.ToLower(level))
-	if err != nil {
+	return NewLoggerTo(level, os.Stdout)
+}
+
+// NewLoggerTo returns a logger writing to out, so that command line tools can
+// keep their own output on stdout apart from the log.
+func NewLoggerTo(level string, out io.Writer) *Logger {
+	if err := SetLevel(level); err != nil {
 		fmt.Printf("Invalid log level '%s', defaulting to 'info'\n", level)
-		logLevel = zerolog.InfoLevel
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	}
 
 	output := zerolog.ConsoleWriter{
-		Out:        os.Stdout,
+		Out:        out,
 		TimeFormat: time.RFC3339,
 	}
 
 	zlog := zerolog.New(output).
-		Level(logLevel).
 		With().
 		Timestamp().
 		Logger()
@@ -34,6 +39,20 @@ func NewLogger(level string) *Logger {
 	return &Logger{zlog: zlog}
 }
 
+// SetLevel changes the level of every logger, including those made with With.
+func SetLevel(level string) error {
+	level = strings.ToLower(level)
+	if level == "warning" {
+		level = "warn"
+	}
+	logLevel, err := zerolog.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(logLevel)
+	return nil
+}
+
 func (l *Logger) Trace(msg string, args ...interface{}) {
 	l.zlog.Trace().Msgf(msg, args...)
 }
@@ -65,3 +84,8 @@ func (l *Logger) Fatal(msg string, args ...interface{}) {
 func (l *Logger) Panel(msg string, args ...interface{}) {
 	l.zlog.Info().Str("source", "panel").Msgf(msg, args...)
 }
+
+// With returns a child logger that tags every message with key=value.
+func (l *Logger) With(key, value string) *Logger {
+	return &Logger{zlog: l.zlog.With().Str(key, value).Logger()}
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/daemonp/texecom2mqtt/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "texecom2mqtt"
+
+// The panel label is the panel name, which is empty without a panels list.
+var (
+	PanelReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "panel_reconnects_total",
+		Help:      "Times the bridge has reconnected to the panel after failing to start it or losing it.",
+	}, []string{"panel"})
+
+	CommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "panel_command_duration_seconds",
+		Help:      "Time from sending a command to the panel to receiving its response, by command byte.",
+		Buckets:   []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"panel", "command"})
+
+	CRCErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "panel_crc_errors_total",
+		Help:      "Messages from the panel dropped for a bad CRC.",
+	}, []string{"panel"})
+
+	Events = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "panel_events_total",
+		Help:      "Events received from the panel, by type.",
+	}, []string{"panel", "type"})
+
+	MQTTPublishFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_publish_failures_total",
+		Help:      "Messages the broker failed to accept. Messages held while disconnected are not counted.",
+	})
+)
+
+var (
+	connectionStateDesc = prometheus.NewDesc(namespace+"_panel_connection_state",
+		"Connection to the panel: 0 disconnected, 1 connecting, 2 connected, 3 logged in, 4 ready.",
+		[]string{"panel"}, nil)
+	zoneStateDesc = prometheus.NewDesc(namespace+"_zone_state",
+		"State of the zone: 0 secure, 1 active, 2 tampered, 3 short.",
+		[]string{"panel", "zone", "id", "name"}, nil)
+	areaArmedDesc = prometheus.NewDesc(namespace+"_area_armed",
+		"1 while the area is armed or part armed, including its entry time, otherwise 0.",
+		[]string{"panel", "area", "id", "name"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(PanelReconnects, CommandDuration, CRCErrors, Events, MQTTPublishFailures)
+}
+
+// PanelObserver counts the traffic with one panel. It is handed to the panel
+// with SetObserver.
+type PanelObserver struct {
+	panel string
+}
+
+func NewPanelObserver(panel string) *PanelObserver {
+	return &PanelObserver{panel: panel}
+}
+
+func (o *PanelObserver) CommandDone(command string, duration time.Duration) {
+	CommandDuration.WithLabelValues(o.panel, command).Observe(duration.Seconds())
+}
+
+func (o *PanelObserver) CRCError() {
+	CRCErrors.WithLabelValues(o.panel).Inc()
+}
+
+func (o *PanelObserver) Event(eventType string) {
+	Events.WithLabelValues(o.panel, eventType).Inc()
+}
+
+// Panel is what the panel metrics are read from.
+type Panel interface {
+	Name() string
+	ConnectionState() types.ConnectionState
+	GetAreas() []types.Area
+	GetZones() []types.Zone
+}
+
+// panelCollector reads the connection, zone and area state of the panels
+// when scraped, so that removed zones and areas disappear with them.
+type panelCollector struct {
+	panels []Panel
+}
+
+func (c *panelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectionStateDesc
+	ch <- zoneStateDesc
+	ch <- areaArmedDesc
+}
+
+func (c *panelCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, p := range c.panels {
+		name := p.Name()
+		ch <- prometheus.MustNewConstMetric(connectionStateDesc, prometheus.GaugeValue, float64(p.ConnectionState()), name)
+
+		for _, zone := range p.GetZones() {
+			if zone.Type == types.ZoneTypeNotUsed {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(zoneStateDesc, prometheus.GaugeValue, float64(zone.Status),
+				name, strconv.Itoa(zone.Number), zone.ID, zone.Name)
+		}
+
+		for _, area := range p.GetAreas() {
+			armed := 0.0
+			switch area.Status {
+			case types.AreaStateArmed, types.AreaStatePartArmed, types.AreaStateInEntry:
+				armed = 1
+			}
+			ch <- prometheus.MustNewConstMetric(areaArmedDesc, prometheus.GaugeValue, armed,
+				name, strconv.Itoa(area.Number), area.ID, area.Name)
+		}
+	}
+}
+
+// Handler serves the metrics of the bridge and of panels.
+func Handler(panels []Panel) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&panelCollector{panels: panels})
+	return promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, registry}, promhttp.HandlerOpts{})
+}
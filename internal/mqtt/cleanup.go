@@ -0,0 +1,59 @@
+package mqtt
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/daemonp/texecom2mqtt/internal/config"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ClearRetained connects to the broker with a client of its own, collects
+// the retained messages on topics matching filters for wait, and clears
+// those for which match returns true. It returns the cleared topics, also
+// those cleared before an error stopped it. Unlike
+// Connect it neither sets a will nor touches the bridge status, so it is
+// safe to run next to a running bridge.
+func ClearRetained(cfg *config.MQTTConfig, filters []string, wait time.Duration, match func(topic string, payload []byte) bool) ([]string, error) {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", cfg.Host, cfg.Port))
+	opts.SetClientID(fmt.Sprintf("%s-cleanup-%d", cfg.ClientID, time.Now().Unix()))
+	opts.SetUsername(cfg.Username)
+	opts.SetPassword(cfg.Password)
+	opts.SetCleanSession(true)
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %v", token.Error())
+	}
+	defer client.Disconnect(250)
+
+	var mu sync.Mutex
+	var topics []string
+	handler := func(c mqtt.Client, msg mqtt.Message) {
+		if !msg.Retained() || len(msg.Payload()) == 0 || !match(msg.Topic(), msg.Payload()) {
+			return
+		}
+		mu.Lock()
+		topics = append(topics, msg.Topic())
+		mu.Unlock()
+	}
+
+	for _, filter := range filters {
+		if token := client.Subscribe(filter, byte(cfg.QOS), handler); token.Wait() && token.Error() != nil {
+			return nil, fmt.Errorf("failed to subscribe to %s: %v", filter, token.Error())
+		}
+	}
+	time.Sleep(wait)
+	client.Unsubscribe(filters...).Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, topic := range topics {
+		if token := client.Publish(topic, byte(cfg.QOS), true, ""); token.Wait() && token.Error() != nil {
+			return topics[:i], fmt.Errorf("failed to clear %s: %v", topic, token.Error())
+		}
+	}
+	return topics, nil
+}
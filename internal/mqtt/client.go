@@ -1,7 +1,14 @@
 package mqtt
 
+import "github.com/daemonp/texecom2mqtt/internal/config"
+
 type MQTTClient interface {
 	GetPrefix() string
 	Topics() *Topics
+	BridgeTopics() *Topics
+	PayloadFormats() config.PayloadConfig
 	Publish(topic string, payload interface{}, retain bool)
+	PublishState()
+	Subscribe(topic string, handler func(payload string))
+	OnConnect(fn func())
 }
@@ -0,0 +1,87 @@
+package mqtt
+
+import (
+	"sort"
+
+	"github.com/daemonp/texecom2mqtt/internal/texecom"
+	"github.com/daemonp/texecom2mqtt/internal/types"
+	"github.com/daemonp/texecom2mqtt/internal/util"
+)
+
+// unknownLogEventType is the event type of log events without a description.
+const unknownLogEventType = "unknown"
+
+// LogEventGroups returns every log event group type, each of which is
+// published to its own topic.
+func LogEventGroups() []types.LogEventGroupType {
+	groups := make([]types.LogEventGroupType, 0, len(types.LogEventGroupTypeDescriptions))
+	for group := range types.LogEventGroupTypeDescriptions {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i] < groups[j] })
+	return groups
+}
+
+// LogEventTypes returns every event type that can appear in the event_type
+// field of a log group payload.
+func LogEventTypes() []string {
+	eventTypes := make([]string, 0, len(texecom.LogEventTypeDescriptions)+1)
+	for _, description := range texecom.LogEventTypeDescriptions {
+		eventTypes = append(eventTypes, util.Slugify(description))
+	}
+	sort.Strings(eventTypes)
+	return append(eventTypes, unknownLogEventType)
+}
+
+func logEventType(event types.LogEvent) string {
+	if description, ok := texecom.LogEventTypeDescriptions[event.Type]; ok {
+		return util.Slugify(description)
+	}
+	return unknownLogEventType
+}
+
+// PublishLogGroupEvent publishes event to the topic of its group, with the
+// areas and zone it refers to resolved to their names.
+func (pc *PanelClient) PublishLogGroupEvent(event types.LogEvent) {
+	payload := map[string]interface{}{
+		"event_type":  logEventType(event),
+		"group_type":  event.GroupType.String(),
+		"description": event.Description,
+		"parameter":   event.Parameter,
+		"time":        event.Time,
+	}
+
+	areas := []map[string]interface{}{}
+	for _, number := range event.AreaNumbers() {
+		if area := pc.findArea(number); area != nil {
+			areas = append(areas, map[string]interface{}{"number": area.Number, "id": area.ID, "name": area.Name})
+		}
+	}
+	payload["areas"] = areas
+
+	if number := event.ZoneNumber(); number > 0 {
+		if zone := pc.findZone(number); zone != nil {
+			payload["zone"] = map[string]interface{}{"number": zone.Number, "id": zone.ID, "name": zone.Name}
+		}
+	}
+
+	pc.mqtt.publish(pc.Topics().LogGroup(event.GroupType), payload, false)
+}
+
+func (pc *PanelClient) findArea(number int) *types.Area {
+	for _, area := range pc.panel.GetAreas() {
+		if area.Number == number {
+			return &area
+		}
+	}
+	return nil
+}
+
+func (pc *PanelClient) findZone(number int) *types.Zone {
+	for _, zone := range pc.panel.GetZones() {
+		if zone.Number == number {
+			return &zone
+		}
+	}
+	return nil
+}
@@ -3,31 +3,58 @@ package mqtt
 import (
 	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/daemonp/texecom2mqtt/internal/cache"
 	"github.com/daemonp/texecom2mqtt/internal/config"
 	"github.com/daemonp/texecom2mqtt/internal/log"
+	"github.com/daemonp/texecom2mqtt/internal/metrics"
 	"github.com/daemonp/texecom2mqtt/internal/panel"
-	"github.com/daemonp/texecom2mqtt/internal/types"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 type MQTT struct {
-	config *config.MQTTConfig
-	panel  *panel.Panel
-	log    *log.Logger
-	client mqtt.Client
-	topics *Topics
-	mu     sync.Mutex
+	config   *config.MQTTConfig
+	log      *log.Logger
+	client   mqtt.Client
+	topics   *Topics
+	panels   []*PanelClient
+	outbox   *outbox
+	retained map[string][]byte
+	handlers map[string][]func(payload string)
+	flushMu  sync.Mutex
+	stop     chan struct{}
+	started  time.Time
+	mu       sync.Mutex
 }
 
-func NewMQTT(cfg *config.MQTTConfig, p *panel.Panel, logger *log.Logger) *MQTT {
+func NewMQTT(cfg *config.MQTTConfig, logger *log.Logger) *MQTT {
+	path := cfg.Outbox.Path
+	if path == "" {
+		if dir, err := cache.Dir(); err == nil {
+			path = filepath.Join(dir, "outbox.json")
+		}
+	}
+	ob, err := newOutbox(path, cfg.Outbox.MaxMessages)
+	if err != nil {
+		logger.Warning("Failed to load MQTT outbox: %v", err)
+	}
+	if size, _ := ob.stats(); size > 0 {
+		logger.Info("Loaded %d undelivered MQTT message(s) from %s", size, path)
+	}
+
 	return &MQTT{
-		config: cfg,
-		panel:  p,
-		log:    logger,
-		topics: NewTopics(cfg.Prefix),
+		config:   cfg,
+		log:      logger,
+		topics:   NewTopics(cfg.Prefix),
+		outbox:   ob,
+		retained: make(map[string][]byte),
+		handlers: make(map[string][]func(payload string)),
+		stop:     make(chan struct{}),
+		started:  time.Now(),
 	}
 }
 
@@ -48,6 +75,86 @@ func (m *MQTT) Publish(topic string, payload interface{}, retain bool) {
 	m.publish(topic, payload, retain)
 }
 
+// AddPanel attaches a panel to the shared connection. Its topics live below
+// the MQTT prefix joined with subPrefix, or directly below the MQTT prefix if
+// subPrefix is empty.
+func (m *MQTT) AddPanel(p *panel.Panel, subPrefix string) *PanelClient {
+	prefix := m.config.Prefix
+	if subPrefix != "" {
+		prefix = fmt.Sprintf("%s/%s", prefix, subPrefix)
+	}
+
+	pc := newPanelClient(m, p, prefix)
+
+	m.mu.Lock()
+	m.panels = append(m.panels, pc)
+	m.mu.Unlock()
+
+	return pc
+}
+
+// Reconfigure applies the MQTT settings that can change while running: QoS,
+// retain flags, topic naming and payload formats. Retained area and zone
+// state published under the old topics or formats is cleared and published
+// again. It changes nothing if the new topic naming would make areas or zones
+// of a panel share a topic.
+func (m *MQTT) Reconfigure(cfg *config.MQTTConfig) error {
+	m.mu.Lock()
+	panels := m.panels
+	m.mu.Unlock()
+
+	topicsChanged := cfg.TopicScheme != m.config.TopicScheme || cfg.TopicTemplate != m.config.TopicTemplate
+	if topicsChanged {
+		for _, pc := range panels {
+			topics, _ := NewTopicsWithScheme(pc.prefix, cfg.TopicScheme, cfg.TopicTemplate).WithLayout(pc.panel.GetAreas(), pc.panel.GetZones())
+			if collisions := topics.Collisions(pc.panel.GetAreas(), pc.panel.GetZones()); len(collisions) > 0 {
+				return fmt.Errorf("topics of panel %s would collide: %s", pc.panel.Name(), strings.Join(collisions, "; "))
+			}
+		}
+	}
+
+	m.mu.Lock()
+	m.config.QOS = cfg.QOS
+	m.config.Retain = cfg.Retain
+	m.config.RetainLog = cfg.RetainLog
+	m.mu.Unlock()
+	if !topicsChanged && cfg.Payload == m.config.Payload {
+		return nil
+	}
+
+	for _, pc := range panels {
+		pc.clearState()
+	}
+	m.mu.Lock()
+	m.config.TopicScheme = cfg.TopicScheme
+	m.config.TopicTemplate = cfg.TopicTemplate
+	m.config.Payload = cfg.Payload
+	m.mu.Unlock()
+	for _, pc := range panels {
+		pc.setTopics(NewTopicsWithScheme(pc.prefix, cfg.TopicScheme, cfg.TopicTemplate))
+	}
+	return nil
+}
+
+// qos, retainLog and payloads read the settings that Reconfigure changes.
+func (m *MQTT) qos() byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return byte(m.config.QOS)
+}
+
+func (m *MQTT) retainLog() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config.RetainLog
+}
+
+func (m *MQTT) payloads() config.PayloadConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config.Payload
+}
+
 func (m *MQTT) Connect() error {
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", m.config.Host, m.config.Port))
@@ -59,7 +166,7 @@ func (m *MQTT) Connect() error {
 	opts.SetOnConnectHandler(m.onConnect)
 	opts.SetConnectionLostHandler(m.onDisconnect)
 
-	opts.SetWill(m.topics.Status(), offlinePayload, byte(m.config.QOS), m.config.Retain)
+	opts.SetWill(m.topics.Status(), offlinePayload, m.qos(), m.config.Retain)
 
 	m.client = mqtt.NewClient(opts)
 
@@ -68,142 +175,262 @@ func (m *MQTT) Connect() error {
 	}
 
 	m.log.Info("Connected to MQTT broker: %s:%d", m.config.Host, m.config.Port)
+
+	go m.diagnosticsLoop()
 	return nil
 }
 
 func (m *MQTT) onConnect(client mqtt.Client) {
 	m.log.Info("MQTT connection established")
 	m.publishOnlineStatus()
-	m.subscribeTopics()
-	m.publishPanelStatus()
+	m.flush()
+
+	m.mu.Lock()
+	panels := m.panels
+	var shared []string
+	for topic := range m.handlers {
+		shared = append(shared, topic)
+	}
+	m.mu.Unlock()
+
+	for _, topic := range shared {
+		m.subscribe(topic, m.dispatch)
+	}
+
+	for _, pc := range panels {
+		pc.onConnect()
+	}
+
+	m.publishDiagnostics()
 }
 
 func (m *MQTT) onDisconnect(client mqtt.Client, err error) {
 	m.log.Error("MQTT connection lost: %v", err)
 }
 
-func (m *MQTT) subscribeTopics() {
-	topics := []string{
-		m.topics.Text(),
-		m.topics.DateTime(),
+// Connected reports whether the broker connection is up.
+func (m *MQTT) Connected() bool {
+	return m.isConnected()
+}
+
+func (m *MQTT) isConnected() bool {
+	return m.client != nil && m.client.IsConnected()
+}
+
+func (m *MQTT) subscribe(topic string, handler mqtt.MessageHandler) {
+	token := m.client.Subscribe(topic, m.qos(), handler)
+	if token.Wait() && token.Error() != nil {
+		m.log.Error("Failed to subscribe to topic %s: %v", topic, token.Error())
+	} else {
+		m.log.Debug("Subscribed to topic: %s", topic)
 	}
+}
 
-	for _, area := range m.panel.GetAreas() {
-		topics = append(topics, m.topics.AreaCommand(area))
+// addHandler registers handler for topic. Unlike subscribe, any number of
+// handlers can share a topic, and the subscription is restored on reconnect.
+func (m *MQTT) addHandler(topic string, handler func(payload string)) {
+	m.mu.Lock()
+	first := len(m.handlers[topic]) == 0
+	m.handlers[topic] = append(m.handlers[topic], handler)
+	m.mu.Unlock()
+
+	if first && m.isConnected() {
+		m.subscribe(topic, m.dispatch)
 	}
+}
 
-	for _, topic := range topics {
-		token := m.client.Subscribe(topic, byte(m.config.QOS), m.handleMessage)
-		if token.Wait() && token.Error() != nil {
-			m.log.Error("Failed to subscribe to topic %s: %v", topic, token.Error())
-		} else {
-			m.log.Debug("Subscribed to topic: %s", topic)
-		}
+func (m *MQTT) dispatch(client mqtt.Client, msg mqtt.Message) {
+	m.mu.Lock()
+	handlers := m.handlers[msg.Topic()]
+	m.mu.Unlock()
+
+	m.log.Debug("Received message on topic %s: %s", msg.Topic(), msg.Payload())
+	for _, handler := range handlers {
+		handler(string(msg.Payload()))
 	}
 }
 
-func (m *MQTT) handleMessage(client mqtt.Client, msg mqtt.Message) {
-	topic := msg.Topic()
-	payload := string(msg.Payload())
+func (m *MQTT) unsubscribe(topics ...string) {
+	token := m.client.Unsubscribe(topics...)
+	if token.Wait() && token.Error() != nil {
+		m.log.Error("Failed to unsubscribe from topics %v: %v", topics, token.Error())
+	}
+}
 
-	m.log.Debug("Received message on topic %s: %s", topic, payload)
+func (m *MQTT) publishOnlineStatus() {
+	m.publish(m.topics.Status(), onlinePayload, true)
+}
 
-	switch topic {
-	case m.topics.Text():
-		m.panel.SetLCDDisplay(payload)
-	case m.topics.DateTime():
-		t, err := time.Parse(time.RFC3339, payload)
+// publish sends message to topic. Strings are sent as they are, anything
+// else is marshalled to JSON. Messages that cannot be delivered are kept:
+// the latest payload of each retained topic in memory and everything else in
+// the outbox, to be sent once the broker is reachable again.
+func (m *MQTT) publish(topic string, message interface{}, retain bool) {
+	var payload []byte
+	switch msg := message.(type) {
+	case string:
+		payload = []byte(msg)
+	default:
+		var err error
+		payload, err = json.Marshal(message)
 		if err != nil {
-			m.log.Error("Invalid datetime format: %s", payload)
+			m.log.Error("Failed to marshal message for topic %s: %v", topic, err)
 			return
 		}
-		m.panel.SetDateTime(t)
-	default:
-		for _, area := range m.panel.GetAreas() {
-			if topic == m.topics.AreaCommand(area) {
-				m.handleAreaCommand(area, payload)
-				return
-			}
+	}
+
+	if !m.isConnected() {
+		m.queue(topic, payload, retain)
+		return
+	}
+
+	// Queue behind older undelivered messages to keep them in order
+	if !retain {
+		if size, _ := m.outbox.stats(); size > 0 {
+			m.queue(topic, payload, retain)
+			return
 		}
-		m.log.Warn("Received message on unknown topic: %s", topic)
-	}
-}
-
-func (m *MQTT) handleAreaCommand(area types.Area, command string) {
-	switch command {
-	case "full_arm":
-		m.panel.Arm(area.Number, types.ArmTypeFull)
-	case "part_arm_1":
-		m.panel.Arm(area.Number, types.ArmTypePartArm1)
-	case "part_arm_2":
-		m.panel.Arm(area.Number, types.ArmTypePartArm2)
-	case "part_arm_3":
-		m.panel.Arm(area.Number, types.ArmTypePartArm3)
-	case "disarm":
-		m.panel.Disarm(area.Number)
-	default:
-		m.log.Warn("Unknown area command: %s", command)
 	}
-}
 
-func (m *MQTT) publishOnlineStatus() {
-	m.publish(m.topics.Status(), onlinePayload, true)
+	if err := m.send(topic, payload, retain); err != nil {
+		m.log.Error("Failed to publish message to topic %s: %v", topic, err)
+		m.queue(topic, payload, retain)
+		return
+	}
+	if retain {
+		m.mu.Lock()
+		delete(m.retained, topic)
+		m.mu.Unlock()
+	}
 }
 
-func (m *MQTT) publishPanelStatus() {
-	device := m.panel.GetDevice()
-	status := map[string]interface{}{
-		"model":            device.Model,
-		"serial_number":    device.SerialNumber,
-		"firmware_version": device.FirmwareVersion,
+func (m *MQTT) send(topic string, payload []byte, retain bool) error {
+	if !m.isConnected() {
+		return fmt.Errorf("not connected")
+	}
+
+	token := m.client.Publish(topic, m.qos(), retain, payload)
+	if token.Wait() && token.Error() != nil {
+		metrics.MQTTPublishFailures.Inc()
+		return token.Error()
 	}
-	m.publish(m.topics.Config(), status, true)
+	m.log.Debug("Published message to topic: %s", topic)
+	return nil
 }
 
-func (m *MQTT) PublishAreaStatus(area types.Area) {
-	status := map[string]interface{}{
-		"id":     area.ID,
-		"name":   area.Name,
-		"number": area.Number,
-		"status": types.AreaStateDescriptions[area.Status],
+func (m *MQTT) queue(topic string, payload []byte, retain bool) {
+	if retain {
+		m.mu.Lock()
+		m.retained[topic] = payload
+		m.mu.Unlock()
+		m.log.Debug("Holding retained message for topic %s until reconnected", topic)
+		return
+	}
+
+	dropped, err := m.outbox.push(topic, payload)
+	if err != nil {
+		m.log.Error("Failed to persist MQTT outbox: %v", err)
 	}
-	if area.Status == types.AreaStatePartArmed {
-		status["part_arm"] = area.PartArm
+	if dropped > 0 {
+		m.log.Warning("MQTT outbox full, dropped %d oldest message(s)", dropped)
+	}
+	m.log.Debug("Queued message for topic %s in outbox", topic)
+
+	if m.isConnected() {
+		go m.flush()
 	}
-	m.publish(m.topics.Area(area), status, true)
 }
 
-func (m *MQTT) PublishZoneStatus(zone types.Zone) {
-	status := map[string]interface{}{
-		"id":     zone.ID,
-		"name":   zone.Name,
-		"number": zone.Number,
-		"status": types.ZoneStateDescriptions[zone.Status],
-		"type":   types.ZoneTypeDescriptions[zone.Type],
+// flush re-publishes held retained messages and replays the outbox in order.
+// It stops at the first failure, leaving the rest for the next reconnect.
+func (m *MQTT) flush() {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	retained := m.retained
+	m.retained = make(map[string][]byte)
+	m.mu.Unlock()
+
+	for topic, payload := range retained {
+		if err := m.send(topic, payload, true); err != nil {
+			m.log.Error("Failed to republish retained message to topic %s: %v", topic, err)
+			m.mu.Lock()
+			if _, ok := m.retained[topic]; !ok {
+				m.retained[topic] = payload
+			}
+			m.mu.Unlock()
+		}
+	}
+
+	replayed := 0
+	defer func() {
+		if replayed == 0 {
+			return
+		}
+		if err := m.outbox.persist(); err != nil {
+			m.log.Error("Failed to persist MQTT outbox: %v", err)
+		}
+		m.log.Info("Replayed %d message(s) from the MQTT outbox", replayed)
+	}()
+
+	for {
+		msg, ok := m.outbox.peek()
+		if !ok {
+			return
+		}
+		if err := m.send(msg.Topic, msg.Payload, false); err != nil {
+			m.log.Error("Failed to replay message to topic %s: %v", msg.Topic, err)
+			return
+		}
+		m.outbox.pop()
+		replayed++
 	}
-	m.publish(m.topics.Zone(zone), status, true)
 }
 
-func (m *MQTT) PublishLogEvent(event types.LogEvent) {
-	m.publish(m.topics.Log(), event, m.config.RetainLog)
+// OutboxStats returns the number of undelivered messages in the outbox and
+// the age of the oldest one.
+func (m *MQTT) OutboxStats() (int, time.Duration) {
+	return m.outbox.stats()
 }
 
-func (m *MQTT) publish(topic string, message interface{}, retain bool) {
-	payload, err := json.Marshal(message)
+func (m *MQTT) diagnosticsLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.publishDiagnostics()
+		}
+	}
+}
+
+// publishDiagnostics publishes the bridge diagnostics. It is sent directly
+// rather than through publish so that it never ends up in the outbox.
+func (m *MQTT) publishDiagnostics() {
+	size, age := m.outbox.stats()
+	if size > 0 {
+		m.log.Warning("MQTT outbox holds %d message(s), oldest queued %s ago", size, age.Round(time.Second))
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"uptime":            int(time.Since(m.started).Seconds()),
+		"outbox_size":       size,
+		"outbox_oldest_age": int(age.Seconds()),
+	})
 	if err != nil {
-		m.log.Error("Failed to marshal message for topic %s: %v", topic, err)
+		m.log.Error("Failed to marshal diagnostics: %v", err)
 		return
 	}
-
-	token := m.client.Publish(topic, byte(m.config.QOS), retain, payload)
-	if token.Wait() && token.Error() != nil {
-		m.log.Error("Failed to publish message to topic %s: %v", topic, token.Error())
-	} else {
-		m.log.Debug("Published message to topic: %s", topic)
+	if err := m.send(m.topics.Diagnostics(), payload, true); err != nil {
+		m.log.Debug("Failed to publish diagnostics: %v", err)
 	}
 }
 
 func (m *MQTT) Close() {
+	close(m.stop)
 	if m.client != nil && m.client.IsConnected() {
 		m.publish(m.topics.Status(), offlinePayload, true)
 		m.client.Disconnect(250)
@@ -0,0 +1,130 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// outbox is a bounded, disk-backed FIFO of non-retained messages that could
+// not be delivered while the broker was unreachable.
+type outbox struct {
+	path     string
+	max      int
+	mu       sync.Mutex
+	messages []outboxMessage
+}
+
+type outboxMessage struct {
+	Topic    string    `json:"topic"`
+	Payload  []byte    `json:"payload"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// newOutbox returns an outbox holding at most max messages, restoring any
+// messages persisted at path by a previous run. An empty path keeps the
+// outbox in memory only.
+func newOutbox(path string, max int) (*outbox, error) {
+	o := &outbox{path: path, max: max}
+	if path == "" {
+		return o, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return o, nil
+		}
+		return o, fmt.Errorf("failed to read outbox: %v", err)
+	}
+	if err := json.Unmarshal(data, &o.messages); err != nil {
+		return o, fmt.Errorf("failed to unmarshal outbox: %v", err)
+	}
+	o.trim()
+	return o, nil
+}
+
+// push appends a message, dropping the oldest message if the outbox is full.
+// It returns the number of messages dropped.
+func (o *outbox) push(topic string, payload []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.messages = append(o.messages, outboxMessage{Topic: topic, Payload: payload, QueuedAt: time.Now()})
+	dropped := o.trim()
+	return dropped, o.save()
+}
+
+// peek returns the oldest message without removing it.
+func (o *outbox) peek() (outboxMessage, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.messages) == 0 {
+		return outboxMessage{}, false
+	}
+	return o.messages[0], true
+}
+
+// pop removes the oldest message. The change is persisted by the next save.
+func (o *outbox) pop() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.messages) > 0 {
+		o.messages = o.messages[1:]
+	}
+}
+
+// stats returns the number of queued messages and the age of the oldest.
+func (o *outbox) stats() (int, time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.messages) == 0 {
+		return 0, 0
+	}
+	return len(o.messages), time.Since(o.messages[0].QueuedAt)
+}
+
+func (o *outbox) persist() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.save()
+}
+
+func (o *outbox) trim() int {
+	if o.max <= 0 || len(o.messages) <= o.max {
+		return 0
+	}
+	dropped := len(o.messages) - o.max
+	o.messages = o.messages[dropped:]
+	return dropped
+}
+
+// save writes the outbox to disk via a temporary file so that a crash never
+// leaves a partially written outbox behind. The caller must hold o.mu.
+func (o *outbox) save() error {
+	if o.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(o.messages)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(o.path), 0755); err != nil {
+		return fmt.Errorf("failed to create outbox directory: %v", err)
+	}
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write outbox: %v", err)
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		return fmt.Errorf("failed to replace outbox: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,646 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/daemonp/texecom2mqtt/internal/config"
+	"github.com/daemonp/texecom2mqtt/internal/panel"
+	"github.com/daemonp/texecom2mqtt/internal/types"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// PanelClient publishes the state of a single panel, and handles its
+// commands, below the panel's own prefix on the shared MQTT connection.
+type PanelClient struct {
+	mqtt   *MQTT
+	panel  *panel.Panel
+	prefix string
+	topics *Topics
+	ready  bool
+	online bool
+	hooks  []func()
+	// restoredAreas and restoredZones were published from the persisted
+	// state, before the panel was read
+	restoredAreas []types.Area
+	restoredZones []types.Zone
+	mu            sync.Mutex
+}
+
+func newPanelClient(m *MQTT, p *panel.Panel, prefix string) *PanelClient {
+	pc := &PanelClient{
+		mqtt:   m,
+		panel:  p,
+		prefix: prefix,
+		topics: NewTopicsWithScheme(prefix, m.config.TopicScheme, m.config.TopicTemplate),
+	}
+	p.AddListener(pc.handlePanelUpdate)
+	return pc
+}
+
+func (pc *PanelClient) GetPrefix() string {
+	return pc.prefix
+}
+
+func (pc *PanelClient) Topics() *Topics {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.topics
+}
+
+func (pc *PanelClient) PayloadFormats() config.PayloadConfig {
+	return pc.mqtt.payloads()
+}
+
+func (pc *PanelClient) Publish(topic string, payload interface{}, retain bool) {
+	pc.mqtt.publish(topic, payload, retain)
+}
+
+// Start is called once the panel has loaded its areas and zones. It
+// subscribes to the panel's command topics and publishes its current state.
+func (pc *PanelClient) Start() {
+	old := pc.useLayout(pc.panel.GetAreas(), pc.panel.GetZones())
+
+	pc.mu.Lock()
+	pc.ready = true
+	pc.online = true
+	pc.mu.Unlock()
+
+	pc.clearRestoredState(old)
+	if pc.mqtt.isConnected() {
+		pc.onConnect()
+	}
+}
+
+// Subscribe calls handler for every message on topic. Handlers run on the
+// MQTT client's goroutine and must not block.
+func (pc *PanelClient) Subscribe(topic string, handler func(payload string)) {
+	pc.mqtt.addHandler(topic, handler)
+}
+
+// OnConnect registers fn to be called after every (re)connection to the
+// broker, once the panel state has been published.
+func (pc *PanelClient) OnConnect(fn func()) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.hooks = append(pc.hooks, fn)
+}
+
+// SetPanelOffline marks the panel as disconnected on its status topic. The
+// next Start marks it online again.
+func (pc *PanelClient) SetPanelOffline() {
+	pc.mu.Lock()
+	pc.online = false
+	pc.mu.Unlock()
+
+	pc.publishPanelOnline()
+}
+
+func (pc *PanelClient) publishPanelOnline() {
+	pc.mu.Lock()
+	online := pc.online
+	pc.mu.Unlock()
+
+	payload := offlinePayload
+	if online {
+		payload = onlinePayload
+	}
+	pc.mqtt.publish(pc.Topics().PanelStatus(), payload, true)
+}
+
+// BridgeTopics returns the topics shared by all panels, such as the bridge
+// status topic.
+func (pc *PanelClient) BridgeTopics() *Topics {
+	return pc.mqtt.topics
+}
+
+func (pc *PanelClient) isReady() bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.ready
+}
+
+func (pc *PanelClient) onConnect() {
+	if !pc.isReady() {
+		return
+	}
+	pc.subscribeTopics()
+	pc.publishPanelOnline()
+	pc.mqtt.publish(pc.Topics().Connection(), pc.panel.ConnectionState().String(), true)
+	pc.publishPanelStatus()
+	pc.PublishState()
+
+	pc.mu.Lock()
+	hooks := pc.hooks
+	pc.mu.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+func (pc *PanelClient) subscribeTopics() {
+	topics := []string{
+		pc.Topics().Text(),
+		pc.Topics().DateTime(),
+		pc.Topics().Refresh(),
+	}
+
+	for _, area := range pc.panel.GetAreas() {
+		topics = append(topics, pc.Topics().AreaCommand(area))
+	}
+
+	for _, zone := range pc.panel.GetZones() {
+		topics = append(topics, pc.Topics().ZoneBypass(zone))
+	}
+
+	for _, topic := range topics {
+		pc.subscribeCommand(topic)
+	}
+}
+
+// subscribeCommand subscribes to a command topic, unless the panel is read
+// only, in which case no command topic is subscribed to at all.
+// subscribeCommand subscribes to a command topic. While the broker is down
+// it does nothing, onConnect subscribes to every command topic again.
+func (pc *PanelClient) subscribeCommand(topic string) {
+	if pc.panel.ReadOnly() || !pc.mqtt.isConnected() {
+		return
+	}
+	pc.mqtt.subscribe(topic, pc.handleMessage)
+}
+
+func (pc *PanelClient) unsubscribeCommand(topic string) {
+	if pc.panel.ReadOnly() || !pc.mqtt.isConnected() {
+		return
+	}
+	pc.mqtt.unsubscribe(topic)
+}
+
+func (pc *PanelClient) handleMessage(client mqtt.Client, msg mqtt.Message) {
+	topic := msg.Topic()
+	payload := string(msg.Payload())
+
+	pc.mqtt.log.Debug("Received message on topic %s: %s", topic, payload)
+
+	switch topic {
+	case pc.Topics().Text():
+		pc.panel.SetLCDDisplay(payload)
+	case pc.Topics().DateTime():
+		if payload == "now" {
+			pc.panel.SetDateTime(time.Now())
+			return
+		}
+		t, err := time.Parse(time.RFC3339, payload)
+		if err != nil {
+			pc.mqtt.log.Error("Invalid datetime format: %s", payload)
+			return
+		}
+		pc.panel.SetDateTime(t)
+	case pc.Topics().Refresh():
+		pc.panel.RequestRefresh()
+	default:
+		for _, area := range pc.panel.GetAreas() {
+			if topic == pc.Topics().AreaCommand(area) {
+				pc.handleAreaCommand(area, payload)
+				return
+			}
+		}
+		for _, zone := range pc.panel.GetZones() {
+			if topic == pc.Topics().ZoneBypass(zone) {
+				pc.handleZoneBypass(zone, payload)
+				return
+			}
+		}
+		pc.mqtt.log.Warn("Received message on unknown topic: %s", topic)
+	}
+}
+
+func (pc *PanelClient) handleAreaCommand(area types.Area, command string) {
+	switch command {
+	case "full_arm":
+		pc.panel.Arm(area.Number, types.ArmTypeFull)
+	case "part_arm_1":
+		pc.panel.Arm(area.Number, types.ArmTypePartArm1)
+	case "part_arm_2":
+		pc.panel.Arm(area.Number, types.ArmTypePartArm2)
+	case "part_arm_3":
+		pc.panel.Arm(area.Number, types.ArmTypePartArm3)
+	case "disarm":
+		pc.panel.Disarm(area.Number)
+	case "reset":
+		pc.panel.Reset(area.Number)
+	default:
+		pc.mqtt.log.Warn("Unknown area command: %s", command)
+	}
+}
+
+func (pc *PanelClient) handleZoneBypass(zone types.Zone, command string) {
+	switch command {
+	case "ON":
+		pc.panel.SetZoneBypass(zone.Number, true)
+	case "OFF":
+		pc.panel.SetZoneBypass(zone.Number, false)
+	default:
+		pc.mqtt.log.Warn("Unknown zone bypass command: %s", command)
+	}
+}
+
+func (pc *PanelClient) handlePanelUpdate(update interface{}) {
+	if state, ok := update.(types.ConnectionState); ok {
+		pc.mqtt.publish(pc.Topics().Connection(), state.String(), true)
+		return
+	}
+	// Updates are published even while the broker is down, publish holds
+	// them until it is back
+	if !pc.isReady() {
+		return
+	}
+
+	switch u := update.(type) {
+	case types.Area:
+		pc.PublishAreaStatus(u)
+	case types.Zone:
+		pc.PublishZoneStatus(u)
+	case types.LogEvent:
+		pc.PublishLogEvent(u)
+		pc.PublishLogGroupEvent(u)
+	case types.PanelChange:
+		pc.handlePanelChange(u)
+	}
+}
+
+// handlePanelChange clears the retained state of removed areas and zones,
+// and of those whose topic moved, then publishes the state of the new ones.
+// Areas and zones can move without being renamed when they start or stop
+// sharing a name with another.
+func (pc *PanelClient) handlePanelChange(change types.PanelChange) {
+	areas, zones := pc.panel.GetAreas(), pc.panel.GetZones()
+	old := pc.useLayout(areas, zones)
+	topics := pc.Topics()
+
+	touched := make(map[int]bool)
+	for _, area := range change.RemovedAreas {
+		pc.unsubscribeCommand(old.AreaCommand(area))
+		pc.clearAreaStatus(old, area)
+	}
+	for _, c := range change.ChangedAreas {
+		touched[c.New.Number] = true
+		if old.Area(c.Old) != topics.Area(c.New) {
+			pc.moveArea(old, c.Old, c.New)
+		}
+		pc.PublishAreaStatus(c.New)
+	}
+	for _, area := range change.AddedAreas {
+		touched[area.Number] = true
+		pc.subscribeCommand(topics.AreaCommand(area))
+		pc.PublishAreaStatus(area)
+	}
+	for _, area := range areas {
+		if !touched[area.Number] && old.Area(area) != topics.Area(area) {
+			pc.moveArea(old, area, area)
+			pc.PublishAreaStatus(area)
+		}
+	}
+
+	touched = make(map[int]bool)
+	for _, zone := range change.RemovedZones {
+		pc.unsubscribeCommand(old.ZoneBypass(zone))
+		pc.clearZoneStatus(old, zone)
+	}
+	for _, c := range change.ChangedZones {
+		touched[c.New.Number] = true
+		if old.Zone(c.Old) != topics.Zone(c.New) {
+			pc.moveZone(old, c.Old, c.New)
+		}
+		pc.PublishZoneStatus(c.New)
+	}
+	for _, zone := range change.AddedZones {
+		touched[zone.Number] = true
+		pc.subscribeCommand(topics.ZoneBypass(zone))
+		pc.PublishZoneStatus(zone)
+	}
+	for _, zone := range zones {
+		if !touched[zone.Number] && old.Zone(zone) != topics.Zone(zone) {
+			pc.moveZone(old, zone, zone)
+			pc.PublishZoneStatus(zone)
+		}
+	}
+}
+
+// moveArea leaves the topics old gave an area for its current ones.
+func (pc *PanelClient) moveArea(old *Topics, before, after types.Area) {
+	pc.unsubscribeCommand(old.AreaCommand(before))
+	pc.clearAreaStatus(old, before)
+	pc.subscribeCommand(pc.Topics().AreaCommand(after))
+}
+
+// moveZone leaves the topics old gave a zone for its current ones.
+func (pc *PanelClient) moveZone(old *Topics, before, after types.Zone) {
+	pc.unsubscribeCommand(old.ZoneBypass(before))
+	pc.clearZoneStatus(old, before)
+	pc.subscribeCommand(pc.Topics().ZoneBypass(after))
+}
+
+// useLayout switches to topics that tell apart the areas and zones of the
+// given layout that share a name, and returns the topics used until now.
+func (pc *PanelClient) useLayout(areas []types.Area, zones []types.Zone) *Topics {
+	old := pc.Topics()
+	topics := pc.withLayout(old, areas, zones)
+	pc.mu.Lock()
+	pc.topics = topics
+	pc.mu.Unlock()
+	return old
+}
+
+// withLayout returns topics that tell apart the areas and zones of the given
+// layout that share a name, logging every topic it had to change and every
+// collision left.
+func (pc *PanelClient) withLayout(topics *Topics, areas []types.Area, zones []types.Zone) *Topics {
+	topics, rewritten := topics.WithLayout(areas, zones)
+	for _, r := range rewritten {
+		pc.mqtt.log.Warning("Topic %s", r)
+	}
+	for _, collision := range topics.Collisions(areas, zones) {
+		pc.mqtt.log.Error("Topic collision: %s", collision)
+	}
+	return topics
+}
+
+// clearState unsubscribes from the area and zone command topics and clears
+// the retained state of every area and zone.
+func (pc *PanelClient) clearState() {
+	if !pc.isReady() {
+		return
+	}
+	topics := pc.Topics()
+	for _, area := range pc.panel.GetAreas() {
+		pc.unsubscribeCommand(topics.AreaCommand(area))
+		pc.clearAreaStatus(topics, area)
+	}
+	for _, zone := range pc.panel.GetZones() {
+		pc.unsubscribeCommand(topics.ZoneBypass(zone))
+		pc.clearZoneStatus(topics, zone)
+	}
+}
+
+// setTopics switches the panel to new topics, subscribing to its area and
+// zone command topics and publishing its state there.
+func (pc *PanelClient) setTopics(topics *Topics) {
+	topics = pc.withLayout(topics, pc.panel.GetAreas(), pc.panel.GetZones())
+	pc.mu.Lock()
+	pc.topics = topics
+	pc.mu.Unlock()
+	if !pc.isReady() || !pc.mqtt.isConnected() {
+		return
+	}
+	for _, area := range pc.panel.GetAreas() {
+		pc.subscribeCommand(pc.Topics().AreaCommand(area))
+	}
+	for _, zone := range pc.panel.GetZones() {
+		pc.subscribeCommand(pc.Topics().ZoneBypass(zone))
+	}
+	pc.PublishState()
+}
+
+// PublishState publishes the current state of every area and zone.
+func (pc *PanelClient) PublishState() {
+	for _, area := range pc.panel.GetAreas() {
+		pc.PublishAreaStatus(area)
+	}
+	for _, zone := range pc.panel.GetZones() {
+		pc.PublishZoneStatus(zone)
+	}
+}
+
+func (pc *PanelClient) publishPanelStatus() {
+	device := pc.panel.GetDevice()
+	status := map[string]interface{}{
+		"model":            device.Model,
+		"serial_number":    device.SerialNumber,
+		"firmware_version": device.FirmwareVersion,
+		"read_only":        pc.panel.ReadOnly(),
+	}
+	pc.mqtt.publish(pc.Topics().Config(), status, true)
+}
+
+func (pc *PanelClient) PublishAreaStatus(area types.Area) {
+	pc.publishAreaStatus(area, false)
+}
+
+func (pc *PanelClient) PublishZoneStatus(zone types.Zone) {
+	pc.publishZoneStatus(zone, false)
+}
+
+// publishAreaStatus publishes the state of area. Stale state is the last
+// known state from before a restart, not yet confirmed by the panel.
+func (pc *PanelClient) publishAreaStatus(area types.Area, stale bool) {
+	format, topic := pc.mqtt.payloads().Area, pc.Topics().Area(area)
+	pc.publishPayload(format, topic, areaStatus(area, stale), types.GetAreaStatus(area), true)
+	pc.publishStale(format, topic, stale)
+}
+
+func (pc *PanelClient) publishZoneStatus(zone types.Zone, stale bool) {
+	format, topic := pc.mqtt.payloads().Zone, pc.Topics().Zone(zone)
+	pc.publishPayload(format, topic, zoneStatus(zone, stale), types.ZoneStateDescriptions[zone.Status], true)
+	pc.publishStale(format, topic, stale)
+}
+
+// publishStale publishes the stale flag of the string format, which has no
+// room for it in the state topic, to <topic>/stale as the fields format does.
+func (pc *PanelClient) publishStale(format, topic string, stale bool) {
+	if format == config.PayloadString {
+		pc.mqtt.publish(fmt.Sprintf("%s/stale", topic), strconv.FormatBool(stale), true)
+	}
+}
+
+// PublishRestoredState publishes the last known state restored from before a
+// restart, marked as stale, until the panel is reached and the state is
+// published again from the panel.
+func (pc *PanelClient) PublishRestoredState() {
+	if pc.isReady() {
+		return
+	}
+
+	areas, zones := pc.panel.GetAreas(), pc.panel.GetZones()
+	pc.useLayout(areas, zones)
+	for _, area := range areas {
+		pc.publishAreaStatus(area, true)
+	}
+	for _, zone := range zones {
+		pc.publishZoneStatus(zone, true)
+	}
+
+	pc.mu.Lock()
+	pc.restoredAreas, pc.restoredZones = areas, zones
+	pc.mu.Unlock()
+}
+
+// clearRestoredState clears the restored state of areas and zones that are
+// no longer published under the same topic now that the panel has been read.
+// old are the topics the restored state was published with.
+func (pc *PanelClient) clearRestoredState(old *Topics) {
+	pc.mu.Lock()
+	areas, zones := pc.restoredAreas, pc.restoredZones
+	pc.restoredAreas, pc.restoredZones = nil, nil
+	pc.mu.Unlock()
+
+	current := make(map[string]bool)
+	for _, area := range pc.panel.GetAreas() {
+		current[pc.Topics().Area(area)] = true
+	}
+	for _, zone := range pc.panel.GetZones() {
+		current[pc.Topics().Zone(zone)] = true
+	}
+
+	for _, area := range areas {
+		if !current[old.Area(area)] {
+			pc.clearAreaStatus(old, area)
+		}
+	}
+	for _, zone := range zones {
+		if !current[old.Zone(zone)] {
+			pc.clearZoneStatus(old, zone)
+		}
+	}
+}
+
+// clearAreaStatus clears the state of area published under topics.
+func (pc *PanelClient) clearAreaStatus(topics *Topics, area types.Area) {
+	format := pc.mqtt.payloads().Area
+	pc.clearPayload(format, topics.Area(area), areaStatus(area, false))
+	pc.clearStale(format, topics.Area(area))
+}
+
+// clearZoneStatus clears the state of zone published under topics.
+func (pc *PanelClient) clearZoneStatus(topics *Topics, zone types.Zone) {
+	format := pc.mqtt.payloads().Zone
+	pc.clearPayload(format, topics.Zone(zone), zoneStatus(zone, false))
+	pc.clearStale(format, topics.Zone(zone))
+}
+
+func (pc *PanelClient) clearStale(format, topic string) {
+	if format == config.PayloadString {
+		pc.mqtt.publish(fmt.Sprintf("%s/stale", topic), "", true)
+	}
+}
+
+// areaStatus is the state published for area, which is AreaPayload with the
+// stale flag.
+func areaStatus(area types.Area, stale bool) map[string]interface{} {
+	status := AreaPayload(area)
+	status["stale"] = stale
+	return status
+}
+
+func zoneStatus(zone types.Zone, stale bool) map[string]interface{} {
+	status := ZonePayload(zone)
+	status["stale"] = stale
+	return status
+}
+
+// AreaPayload is the JSON status published for an area.
+func AreaPayload(area types.Area) map[string]interface{} {
+	status := map[string]interface{}{
+		"id":     area.ID,
+		"name":   area.Name,
+		"number": area.Number,
+		"status": types.AreaStateDescriptions[area.Status],
+	}
+	if area.Status == types.AreaStatePartArmed {
+		status["part_arm"] = area.PartArm
+	}
+	for name, set := range area.Flags.Fields() {
+		status[name] = set
+	}
+	status["raw_flags"] = area.Flags.RawHex()
+	return status
+}
+
+// ZonePayload is the JSON status published for a zone.
+func ZonePayload(zone types.Zone) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              zone.ID,
+		"name":            zone.Name,
+		"number":          zone.Number,
+		"status":          types.ZoneStateDescriptions[zone.Status],
+		"type":            types.ZoneTypeDescriptions[zone.Type],
+		"tamper":          zone.Status == types.ZoneStateTampered,
+		"fault":           zone.Flags.Fault,
+		"failed_test":     zone.Flags.FailedTest,
+		"alarmed":         zone.Flags.Alarmed,
+		"masked":          zone.Flags.Masked,
+		"problem":         zone.Flags.Problem(),
+		"bypassed":        zone.Flags.Bypassed(),
+		"manual_bypassed": zone.Flags.ManualBypassed,
+		"auto_bypassed":   zone.Flags.AutoBypassed,
+	}
+}
+
+func (pc *PanelClient) PublishLogEvent(event types.LogEvent) {
+	pc.publishPayload(pc.mqtt.payloads().Log, pc.Topics().Log(), event, event.Description, pc.mqtt.retainLog())
+}
+
+// publishPayload publishes message to topic in the given payload format. The
+// string format publishes value instead, and the fields format publishes
+// every field of message to its own subtopic.
+func (pc *PanelClient) publishPayload(format, topic string, message interface{}, value string, retain bool) {
+	switch format {
+	case config.PayloadString:
+		pc.mqtt.publish(topic, value, retain)
+	case config.PayloadFields:
+		fields, err := toFields(message)
+		if err != nil {
+			pc.mqtt.log.Error("Failed to split message for topic %s into fields: %v", topic, err)
+			return
+		}
+		for name, field := range fields {
+			pc.mqtt.publish(fmt.Sprintf("%s/%s", topic, name), field, retain)
+		}
+	default:
+		pc.mqtt.publish(topic, message, retain)
+	}
+}
+
+// clearPayload removes the retained payloads that publishPayload published
+// for message.
+func (pc *PanelClient) clearPayload(format, topic string, message interface{}) {
+	if format != config.PayloadFields {
+		pc.mqtt.publish(topic, "", true)
+		return
+	}
+	fields, err := toFields(message)
+	if err != nil {
+		pc.mqtt.log.Error("Failed to split message for topic %s into fields: %v", topic, err)
+		return
+	}
+	for name := range fields {
+		pc.mqtt.publish(fmt.Sprintf("%s/%s", topic, name), "", true)
+	}
+}
+
+// toFields converts message into a map of field name to payload. Strings are
+// published as they are, anything else as JSON.
+func toFields(message interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, field := range fields {
+		if _, ok := field.(string); !ok {
+			encoded, err := json.Marshal(field)
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = string(encoded)
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,61 @@
+package mqtt
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daemonp/texecom2mqtt/internal/config"
+	"github.com/daemonp/texecom2mqtt/internal/log"
+	"github.com/daemonp/texecom2mqtt/internal/panel"
+	"github.com/daemonp/texecom2mqtt/internal/types"
+)
+
+// newDisconnectedClient returns a ready panel client whose broker has never
+// been reached.
+func newDisconnectedClient(t *testing.T) *PanelClient {
+	t.Helper()
+	logger := log.NewLoggerTo("fatal", io.Discard)
+	m := NewMQTT(&config.MQTTConfig{
+		Prefix: "texecom2mqtt",
+		Outbox: config.OutboxConfig{Path: filepath.Join(t.TempDir(), "outbox.json"), MaxMessages: 10},
+	}, logger)
+
+	pc := m.AddPanel(panel.NewPanel(&config.PanelConfig{}, logger), "")
+	pc.ready = true
+	return pc
+}
+
+func TestLogEventQueuedWhileDisconnected(t *testing.T) {
+	pc := newDisconnectedClient(t)
+
+	pc.handlePanelUpdate(types.LogEvent{
+		Type:        1,
+		GroupType:   types.LogEventGroupTypeAlarm,
+		Time:        time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		Description: "Entry/Exit 1",
+	})
+
+	size, _ := pc.mqtt.outbox.stats()
+	if size != 2 {
+		t.Fatalf("outbox holds %d messages, want the log event and its group event", size)
+	}
+	message, _ := pc.mqtt.outbox.peek()
+	if message.Topic != "texecom2mqtt/log" {
+		t.Errorf("first queued topic = %q, want texecom2mqtt/log", message.Topic)
+	}
+}
+
+func TestAreaStateHeldWhileDisconnected(t *testing.T) {
+	pc := newDisconnectedClient(t)
+
+	pc.handlePanelUpdate(types.Area{Number: 1, ID: "A", Name: "House", Status: types.AreaStateInAlarm})
+
+	pc.mqtt.mu.Lock()
+	_, held := pc.mqtt.retained["texecom2mqtt/area/house"]
+	pc.mqtt.mu.Unlock()
+	if !held {
+		t.Error("area state was not held for the broker to come back")
+	}
+}
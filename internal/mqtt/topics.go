@@ -2,43 +2,101 @@ package mqtt
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
 
+	"github.com/daemonp/texecom2mqtt/internal/config"
 	"github.com/daemonp/texecom2mqtt/internal/types"
 	"github.com/daemonp/texecom2mqtt/internal/util"
 )
 
 type Topics struct {
-	prefix string
+	prefix   string
+	scheme   string
+	template *template.Template
+	// shared holds the area and zone names, as "<kind>/<name>", that more
+	// than one area or zone of the layout would have
+	shared map[string]bool
+}
+
+// TopicData is passed to a topic_template to build the area or zone part of
+// a topic.
+type TopicData struct {
+	Kind   string
+	Number int
+	ID     string
+	Name   string
+	Slug   string
 }
 
 func NewTopics(prefix string) *Topics {
-	return &Topics{prefix: prefix}
+	return &Topics{prefix: prefix, scheme: config.TopicSchemeSlug}
+}
+
+// NewTopicsWithScheme returns topics that name areas and zones using the
+// given scheme. The template is only used by config.TopicSchemeTemplate and
+// has already been checked by config.LoadConfig.
+func NewTopicsWithScheme(prefix, scheme, tmpl string) *Topics {
+	t := NewTopics(prefix)
+	if scheme != "" {
+		t.scheme = scheme
+	}
+	if t.scheme == config.TopicSchemeTemplate {
+		t.template = template.Must(template.New("topic").Option("missingkey=error").Parse(tmpl))
+	}
+	return t
 }
 
 func (t *Topics) Status() string {
 	return fmt.Sprintf("%s/status", t.prefix)
 }
 
+// PanelStatus is where the bridge publishes whether it is connected to the
+// panel. It is kept apart from Status, the bridge's last will, which shares
+// the prefix of a single panel.
+func (t *Topics) PanelStatus() string {
+	return fmt.Sprintf("%s/panel/status", t.prefix)
+}
+
+func (t *Topics) Connection() string {
+	return fmt.Sprintf("%s/connection", t.prefix)
+}
+
+func (t *Topics) Diagnostics() string {
+	return fmt.Sprintf("%s/diagnostics", t.prefix)
+}
+
 func (t *Topics) Config() string {
 	return fmt.Sprintf("%s/config", t.prefix)
 }
 
 func (t *Topics) Area(area types.Area) string {
-	return fmt.Sprintf("%s/area/%s", t.prefix, util.Slugify(area.Name))
+	return fmt.Sprintf("%s/area/%s", t.prefix, t.name("area", area.Number, area.ID, area.Name))
 }
 
 func (t *Topics) AreaCommand(area types.Area) string {
-	return fmt.Sprintf("%s/area/%s/command", t.prefix, util.Slugify(area.Name))
+	return fmt.Sprintf("%s/command", t.Area(area))
 }
 
 func (t *Topics) Zone(zone types.Zone) string {
-	return fmt.Sprintf("%s/zone/%s", t.prefix, util.Slugify(zone.Name))
+	return fmt.Sprintf("%s/zone/%s", t.prefix, t.name("zone", zone.Number, zone.ID, zone.Name))
+}
+
+func (t *Topics) ZoneBypass(zone types.Zone) string {
+	return fmt.Sprintf("%s/bypass", t.Zone(zone))
 }
 
 func (t *Topics) Log() string {
 	return fmt.Sprintf("%s/log", t.prefix)
 }
 
+// LogGroup is where log events of a single group type are published.
+func (t *Topics) LogGroup(group types.LogEventGroupType) string {
+	return fmt.Sprintf("%s/log/%s", t.prefix, group)
+}
+
 func (t *Topics) Text() string {
 	return fmt.Sprintf("%s/text", t.prefix)
 }
@@ -46,3 +104,109 @@ func (t *Topics) Text() string {
 func (t *Topics) DateTime() string {
 	return fmt.Sprintf("%s/datetime", t.prefix)
 }
+
+func (t *Topics) Refresh() string {
+	return fmt.Sprintf("%s/refresh", t.prefix)
+}
+
+// WithLayout returns a copy of t that tells apart the areas or zones of the
+// given layout that would share a name, such as two zones called PIR, by
+// appending their number, e.g. pir-3 and pir-4. Unused zones are ignored.
+// It also describes every shared topic and the topics used instead. Topics
+// are immutable, so the copy can replace t while t is in use.
+func (t *Topics) WithLayout(areas []types.Area, zones []types.Zone) (*Topics, []string) {
+	type owner struct {
+		name   string
+		number int
+	}
+	owners := make(map[string][]owner)
+	for _, area := range areas {
+		key := "area/" + t.baseName("area", area.Number, area.ID, area.Name)
+		owners[key] = append(owners[key], owner{fmt.Sprintf("area %d (%s)", area.Number, area.Name), area.Number})
+	}
+	for _, zone := range zones {
+		if zone.Type == types.ZoneTypeNotUsed {
+			continue
+		}
+		key := "zone/" + t.baseName("zone", zone.Number, zone.ID, zone.Name)
+		owners[key] = append(owners[key], owner{fmt.Sprintf("zone %d (%s)", zone.Number, zone.Name), zone.Number})
+	}
+
+	n := *t
+	n.shared = make(map[string]bool)
+	var rewritten []string
+	for key, list := range owners {
+		if len(list) < 2 {
+			continue
+		}
+		n.shared[key] = true
+		renames := make([]string, len(list))
+		for i, o := range list {
+			renames[i] = fmt.Sprintf("%s/%s-%d for %s", t.prefix, key, o.number, o.name)
+		}
+		rewritten = append(rewritten, fmt.Sprintf("%s/%s is shared, using %s", t.prefix, key, strings.Join(renames, ", ")))
+	}
+	sort.Strings(rewritten)
+	return &n, rewritten
+}
+
+// name returns the topic segment identifying an area or zone, with its
+// number appended if another area or zone shares its name.
+func (t *Topics) name(kind string, number int, id, name string) string {
+	base := t.baseName(kind, number, id, name)
+	if t.shared[kind+"/"+base] {
+		return fmt.Sprintf("%s-%d", base, number)
+	}
+	return base
+}
+
+func (t *Topics) baseName(kind string, number int, id, name string) string {
+	switch t.scheme {
+	case config.TopicSchemeNumber:
+		return strconv.Itoa(number)
+	case config.TopicSchemeID:
+		return id
+	case config.TopicSchemeTemplate:
+		var sb strings.Builder
+		data := TopicData{Kind: kind, Number: number, ID: id, Name: name, Slug: util.Slugify(name)}
+		if err := t.template.Execute(&sb, data); err != nil {
+			return strconv.Itoa(number)
+		}
+		return strings.Trim(strings.TrimSpace(sb.String()), "/")
+	default:
+		return util.Slugify(name)
+	}
+}
+
+// Collisions returns a description of every area or zone topic that is
+// shared by more than one area or zone even after WithLayout has told apart
+// shared names, such as a zone named pir-3 next to two zones named PIR.
+// Unused zones are ignored.
+func (t *Topics) Collisions(areas []types.Area, zones []types.Zone) []string {
+	owners := make(map[string][]string)
+	for _, area := range areas {
+		topic := t.Area(area)
+		owners[topic] = append(owners[topic], fmt.Sprintf("area %d (%s)", area.Number, area.Name))
+	}
+	for _, zone := range zones {
+		if zone.Type == types.ZoneTypeNotUsed {
+			continue
+		}
+		topic := t.Zone(zone)
+		owners[topic] = append(owners[topic], fmt.Sprintf("zone %d (%s)", zone.Number, zone.Name))
+	}
+
+	var collisions []string
+	for topic, names := range owners {
+		if len(names) > 1 {
+			collisions = append(collisions, fmt.Sprintf("%s is used by %s", topic, util.JoinWithOr(names)))
+		}
+	}
+	sort.Strings(collisions)
+	return collisions
+}
+
+// Prefix returns the prefix all topics are built from.
+func (t *Topics) Prefix() string {
+	return t.prefix
+}
@@ -0,0 +1,34 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/daemonp/texecom2mqtt/internal/types"
+)
+
+func TestWithLayoutRewritesSharedNames(t *testing.T) {
+	zones := []types.Zone{
+		{Number: 3, Name: "PIR", Type: types.ZoneTypeGuard},
+		{Number: 4, Name: "PIR", Type: types.ZoneTypeGuard},
+		{Number: 5, Name: "Front Door", Type: types.ZoneTypeEntryExit1},
+		{Number: 6, Name: "PIR"},
+	}
+	topics, rewritten := NewTopics("texecom2mqtt").WithLayout(nil, zones)
+
+	for zone, want := range map[int]string{
+		0: "texecom2mqtt/zone/pir-3",
+		1: "texecom2mqtt/zone/pir-4",
+		2: "texecom2mqtt/zone/front-door",
+	} {
+		if got := topics.Zone(zones[zone]); got != want {
+			t.Errorf("Zone(%d) = %s, want %s", zones[zone].Number, got, want)
+		}
+	}
+	want := "texecom2mqtt/zone/pir is shared, using texecom2mqtt/zone/pir-3 for zone 3 (PIR), texecom2mqtt/zone/pir-4 for zone 4 (PIR)"
+	if len(rewritten) != 1 || rewritten[0] != want {
+		t.Errorf("rewritten = %q, want [%q]", rewritten, want)
+	}
+	if collisions := topics.Collisions(nil, zones); len(collisions) != 0 {
+		t.Errorf("Collisions() = %q, want none", collisions)
+	}
+}
@@ -0,0 +1,74 @@
+package panel
+
+import (
+	"github.com/daemonp/texecom2mqtt/internal/config"
+	"github.com/daemonp/texecom2mqtt/internal/types"
+)
+
+// applyOverrides merges the areas and zones sections of the panel config onto
+// areas and zones read from the panel, and warns about overrides that do not
+// match any area or zone.
+func (p *Panel) applyOverrides(areas []types.Area, zones []types.Zone) {
+	matchedAreas := make(map[*config.AreaConfig]bool)
+	for i := range areas {
+		area := &areas[i]
+		area.Ignored = false
+		area.HomeAssistant = nil
+
+		ac := p.config.Area(area.Number, area.ID)
+		if ac == nil {
+			continue
+		}
+		matchedAreas[ac] = true
+
+		if ac.Name != "" {
+			area.Name = ac.Name
+		}
+		area.Ignored = ac.Ignore
+		area.HomeAssistant = &types.HomeAssistantArea{
+			FullArm:            ac.FullArm,
+			PartArm1:           ac.PartArm1,
+			PartArm2:           ac.PartArm2,
+			PartArm3:           ac.PartArm3,
+			Code:               ac.Code,
+			CodeArmRequired:    ac.CodeArmRequired,
+			CodeDisarmRequired: ac.CodeDisarmRequired,
+			Icon:               ac.Icon,
+			Hidden:             ac.Hidden,
+		}
+	}
+
+	matchedZones := make(map[*config.ZoneConfig]bool)
+	for i := range zones {
+		zone := &zones[i]
+		zone.Ignored = false
+		zone.HomeAssistant = nil
+
+		zc := p.config.Zone(zone.Number, zone.ID)
+		if zc == nil {
+			continue
+		}
+		matchedZones[zc] = true
+
+		if zc.Name != "" {
+			zone.Name = zc.Name
+		}
+		zone.Ignored = zc.Ignore
+		zone.HomeAssistant = &types.HomeAssistantZone{
+			DeviceClass: zc.DeviceClass,
+			Icon:        zc.Icon,
+			Hidden:      zc.Hidden,
+		}
+	}
+
+	for i, ac := range p.config.Areas {
+		if !matchedAreas[&p.config.Areas[i]] {
+			p.log.Warn("Area override %q (%s) does not match any area on the panel", ac.ID, ac.Name)
+		}
+	}
+	for i, zc := range p.config.Zones {
+		if !matchedZones[&p.config.Zones[i]] {
+			p.log.Warn("Zone override %q (%s) does not match any zone on the panel", zc.ID, zc.Name)
+		}
+	}
+}
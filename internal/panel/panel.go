@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/daemonp/texecom2mqtt/internal/cache"
 	"github.com/daemonp/texecom2mqtt/internal/config"
 	"github.com/daemonp/texecom2mqtt/internal/log"
 	"github.com/daemonp/texecom2mqtt/internal/texecom"
@@ -13,7 +14,7 @@ import (
 )
 
 type Panel struct {
-	config     *config.Config
+	config     *config.PanelConfig
 	log        *log.Logger
 	texecom    *texecom.Texecom
 	areas      []types.Area
@@ -21,24 +22,75 @@ type Panel struct {
 	device     types.Device
 	mu         sync.Mutex
 	isLoggedIn bool
+	listeners  []Listener
+	refresh    chan struct{}
+	connState  types.ConnectionState
+	useCache   bool
+	readCache  bool
+	// lastLogEvent and dirty back the persisted state of the panel
+	lastLogEvent *types.LogEvent
+	dirty        bool
+	persistState bool
+	// saveFailed is set while the state cannot be saved
+	saveFailed bool
 }
 
-func NewPanel(cfg *config.Config, logger *log.Logger) *Panel {
+// Listener is called with the updated types.Area, types.Zone,
+// types.LogEvent, types.PanelChange or types.ConnectionState after the panel
+// state has changed.
+type Listener func(update interface{})
+
+func NewPanel(cfg *config.PanelConfig, logger *log.Logger) *Panel {
+	t := texecom.NewTexecom(logger)
+	t.SetReadOnly(cfg.Texecom.ReadOnly)
+	// The panel keeps its own copy of its config, whose overrides
+	// SetOverrides replaces
+	config := *cfg
 	return &Panel{
-		config:  cfg,
+		config:  &config,
 		log:     logger,
-		texecom: texecom.NewTexecom(logger),
+		texecom: t,
+		refresh: make(chan struct{}, 1),
 	}
 }
 
+// ReadOnly reports whether the panel refuses commands that could change its
+// state.
+func (p *Panel) ReadOnly() bool {
+	return p.config.Texecom.ReadOnly
+}
+
+// SetObserver makes o observe the traffic with the panel, such as to keep
+// metrics. It must be called before connecting.
+func (p *Panel) SetObserver(o texecom.Observer) {
+	p.texecom.SetObserver(o)
+}
+
+// EnableCache makes the panel keep its area and zone text in the cache, and
+// read it from there on start instead of from the panel while it is valid.
+func (p *Panel) EnableCache() {
+	p.useCache = true
+}
+
+// UseCachedLayout makes the panel read its area and zone text from the cache
+// while it is valid, like EnableCache, but never write the cache or persist
+// its state, which the bridge owns. It is meant for tools run alongside the
+// bridge.
+func (p *Panel) UseCachedLayout() {
+	p.readCache = true
+}
+
 func (p *Panel) Connect() error {
 	p.log.Info("Connecting to panel...")
+	p.setConnectionState(types.ConnectionStateConnecting)
 	p.log.Debug("Attempting connection to %s:%d", p.config.Texecom.Host, p.config.Texecom.Port)
 	err := p.texecom.Connect(p.config.Texecom.Host, p.config.Texecom.Port)
 	if err != nil {
 		p.log.Error("Failed to connect to panel: %v", err)
+		p.setConnectionState(types.ConnectionStateDisconnected)
 		return fmt.Errorf("failed to connect to panel: %v", err)
 	}
+	p.setConnectionState(types.ConnectionStateConnected)
 	p.log.Info("Connected to panel")
 	return nil
 }
@@ -52,6 +104,7 @@ func (p *Panel) Login() error {
 		return fmt.Errorf("failed to log in to panel: %v", err)
 	}
 	p.isLoggedIn = true
+	p.setConnectionState(types.ConnectionStateLoggedIn)
 	p.log.Info("Successfully logged in to panel")
 	return nil
 }
@@ -69,93 +122,215 @@ func (p *Panel) Start() error {
 		return fmt.Errorf("failed to load initial data: %v", err)
 	}
 
+	done := p.texecom.Done()
+
 	p.log.Debug("Starting event listener")
-	go p.listenForEvents()
+	go p.listenForEvents(p.texecom.Events())
 
 	p.log.Debug("Starting keepalive routine")
-	go p.keepalive()
+	go p.keepalive(done)
+
+	p.log.Debug("Starting configuration refresh routine")
+	go p.refreshLoop(done)
 
+	p.setConnectionState(types.ConnectionStateReady)
 	p.log.Info("Panel operations started successfully")
 	return nil
 }
 
 func (p *Panel) loadInitialData() error {
+	if err := p.readIdentification(); err != nil {
+		return err
+	}
+
 	var err error
+	p.areas, p.zones, err = p.loadLayout()
+	if err != nil {
+		return err
+	}
+
+	p.log.Debug("Updating zone states")
+	if err := p.updateZoneStates(); err != nil {
+		return fmt.Errorf("failed to update zone states: %v", err)
+	}
+
+	p.log.Debug("Updating area states")
+	if err := p.updateAreaStates(); err != nil {
+		return fmt.Errorf("failed to update area states: %v", err)
+	}
+
+	p.mu.Lock()
+	p.dirty = true
+	p.mu.Unlock()
+	p.saveState()
+
+	p.log.Info("Initial data loaded successfully")
+	return nil
+}
 
+func (p *Panel) readIdentification() error {
 	p.log.Debug("Fetching panel identification")
-	p.device, err = p.texecom.GetPanelIdentification()
+	device, err := p.texecom.GetPanelIdentification()
 	if err != nil {
 		return fmt.Errorf("failed to get panel identification: %v", err)
 	}
-	p.log.Debug("Panel identification: %+v", p.device)
+	device.Model = normalize(device.Model)
+	device.SerialNumber = normalize(device.SerialNumber)
+	device.FirmwareVersion = normalize(device.FirmwareVersion)
+	if device.SerialNumber == "" {
+		device.SerialNumber = p.texecom.SerialNumber()
+	}
+	p.log.Debug("Panel identification: %+v", device)
 
-	p.log.Debug("Fetching areas")
-	p.areas, err = p.texecom.GetAllAreas()
+	p.mu.Lock()
+	p.device = device
+	p.mu.Unlock()
+	return nil
+}
+
+// ReadLayout reads the panel identification, areas and zones without
+// starting panel operations, for tools that only need to look at the panel.
+func (p *Panel) ReadLayout() error {
+	if !p.isLoggedIn {
+		return fmt.Errorf("not logged in to panel")
+	}
+	if err := p.readIdentification(); err != nil {
+		return err
+	}
+
+	areas, zones, err := p.fetchLayout()
 	if err != nil {
-		return fmt.Errorf("failed to get areas: %v", err)
+		return err
 	}
-	p.log.Debug("Fetched %d areas", len(p.areas))
+	p.mu.Lock()
+	p.areas = areas
+	p.zones = zones
+	p.mu.Unlock()
+	return nil
+}
 
-	p.log.Debug("Fetching zones")
-	p.zones, err = p.texecom.GetAllZones()
+// loadLayout returns the area and zone text and zone types from the cache
+// if it is enabled and still valid for the panel, and from the panel
+// otherwise.
+func (p *Panel) loadLayout() ([]types.Area, []types.Zone, error) {
+	if !p.useCache && !p.readCache {
+		return p.fetchLayout()
+	}
+
+	device := p.GetDevice()
+	data, err := cache.LoadCache(device.SerialNumber)
+	switch {
+	case err != nil:
+		p.log.Warning("Failed to load cache: %v", err)
+	case data == nil:
+		p.log.Debug("No cached panel configuration")
+	default:
+		if err := cache.Validate(data, device); err != nil {
+			p.log.Info("Ignoring cached panel configuration: %v", err)
+			break
+		}
+		p.log.Info("Loaded %d areas and %d zones from cache", len(data.Areas), len(data.Zones))
+		p.applyOverrides(data.Areas, data.Zones)
+		return data.Areas, data.Zones, nil
+	}
+
+	return p.fetchLayout()
+}
+
+// fetchLayout reads the area and zone text and zone types from the panel,
+// updating the cache if it is enabled.
+func (p *Panel) fetchLayout() ([]types.Area, []types.Zone, error) {
+	p.log.Debug("Fetching areas")
+	areas, err := p.texecom.GetAllAreas()
 	if err != nil {
-		return fmt.Errorf("failed to get zones: %v", err)
+		return nil, nil, fmt.Errorf("failed to get areas: %v", err)
 	}
-	p.log.Debug("Fetched %d zones", len(p.zones))
+	p.log.Debug("Fetched %d areas", len(areas))
 
-	for i, area := range p.areas {
-		p.areas[i].Name = normalize(area.Name)
+	p.log.Debug("Fetching zones")
+	zones, err := p.texecom.GetAllZones()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get zones: %v", err)
 	}
+	p.log.Debug("Fetched %d zones", len(zones))
 
-	for i, zone := range p.zones {
-		p.zones[i].Name = normalize(zone.Name)
+	for i, area := range areas {
+		areas[i].Name = normalize(area.Name)
 	}
 
-	p.log.Debug("Updating zone states")
-	if err := p.updateZoneStates(); err != nil {
-		return fmt.Errorf("failed to update zone states: %v", err)
+	for i, zone := range zones {
+		zones[i].Name = normalize(zone.Name)
 	}
 
-	p.log.Debug("Updating area states")
-	if err := p.updateAreaStates(); err != nil {
-		return fmt.Errorf("failed to update area states: %v", err)
+	// The cache holds the panel's own text, before overrides
+	if p.useCache {
+		device := p.GetDevice()
+		if err := cache.SaveCache(device.SerialNumber, device, areas, zones); err != nil {
+			p.log.Warning("Failed to save cache: %v", err)
+		} else {
+			p.log.Debug("Saved panel configuration to cache")
+		}
 	}
 
-	p.log.Info("Initial data loaded successfully")
-	return nil
+	p.applyOverrides(areas, zones)
+	return areas, zones, nil
 }
 
-func (p *Panel) listenForEvents() {
-	for event := range p.texecom.Events() {
+func (p *Panel) listenForEvents(events <-chan interface{}) {
+	for event := range events {
 		p.handleEvent(event)
 	}
 }
 
 func (p *Panel) handleEvent(event interface{}) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-
+	var update interface{}
 	switch e := event.(type) {
 	case types.ZoneEvent:
-		p.handleZoneEvent(e)
+		update = p.handleZoneEvent(e)
 	case types.AreaEvent:
-		p.handleAreaEvent(e)
+		update = p.handleAreaEvent(e)
 	case types.LogEvent:
-		p.handleLogEvent(e)
+		update = p.handleLogEvent(e)
+	}
+	p.dirty = true
+	p.mu.Unlock()
+
+	if update != nil {
+		p.notify(update)
 	}
 }
 
-func (p *Panel) handleZoneEvent(event types.ZoneEvent) {
+func (p *Panel) notify(updates ...interface{}) {
+	p.mu.Lock()
+	listeners := p.listeners
+	p.mu.Unlock()
+
+	for _, update := range updates {
+		for _, listener := range listeners {
+			listener(update)
+		}
+	}
+}
+
+func (p *Panel) handleZoneEvent(event types.ZoneEvent) interface{} {
 	for i, zone := range p.zones {
 		if zone.Number == event.ZoneNumber {
 			p.zones[i].Status = event.ZoneState
+			p.zones[i].Flags = event.Flags
 			p.log.Info("Zone %s (%d) status changed to %s", zone.Name, zone.Number, event.ZoneState)
-			break
+			if zone.Ignored {
+				return nil
+			}
+			return p.zones[i]
 		}
 	}
+	p.log.Warn("Event for unknown zone %d, re-reading panel configuration", event.ZoneNumber)
+	p.RequestRefresh()
+	return nil
 }
 
-func (p *Panel) handleAreaEvent(event types.AreaEvent) {
+func (p *Panel) handleAreaEvent(event types.AreaEvent) interface{} {
 	for i, area := range p.areas {
 		if area.Number == event.AreaNumber {
 			p.areas[i].Status = event.AreaState
@@ -163,24 +338,239 @@ func (p *Panel) handleAreaEvent(event types.AreaEvent) {
 				p.areas[i].PartArm = event.PartArm
 			}
 			p.log.Info("Area %s (%d) status changed to %s", area.Name, area.Number, event.AreaState)
-			break
+			if area.Ignored {
+				return nil
+			}
+			return p.areas[i]
 		}
 	}
+	p.log.Warn("Event for unknown area %d, re-reading panel configuration", event.AreaNumber)
+	p.RequestRefresh()
+	return nil
 }
 
-func (p *Panel) handleLogEvent(event types.LogEvent) {
+// handleLogEvent drops an event that repeats the last one, which includes the
+// last event of the previous run restored from the persisted state, so that
+// it is not published twice.
+func (p *Panel) handleLogEvent(event types.LogEvent) interface{} {
+	if last := p.lastLogEvent; last != nil && sameLogEvent(*last, event) {
+		p.log.Debug("Ignoring repeated log event: %s", event.Description)
+		return nil
+	}
 	p.log.Panel("Log event: %s", event.Description)
+	p.lastLogEvent = &event
+	return event
 }
 
-func (p *Panel) keepalive() {
+func sameLogEvent(a, b types.LogEvent) bool {
+	return a.Type == b.Type && a.GroupType == b.GroupType && a.Parameter == b.Parameter &&
+		a.Areas == b.Areas && a.Time.Equal(b.Time) && a.Description == b.Description
+}
+
+// AddListener registers fn to be notified of area, zone and log updates.
+func (p *Panel) AddListener(fn Listener) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listeners = append(p.listeners, fn)
+}
+
+func (p *Panel) keepalive(done <-chan struct{}) {
 	ticker := time.NewTicker(30 * time.Second)
-	for range ticker.C {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
 		if err := p.texecom.UpdateSystemPower(); err != nil {
 			p.log.Error("Failed to update system power: %v", err)
 		}
+		if err := p.refreshAreaStates(); err != nil {
+			p.log.Error("Failed to refresh area states: %v", err)
+		}
+		p.saveState()
+	}
+}
+
+// RequestRefresh asks for the area and zone configuration to be re-read from
+// the panel. Requests made while a refresh is pending are coalesced.
+func (p *Panel) RequestRefresh() {
+	select {
+	case p.refresh <- struct{}{}:
+	default:
 	}
 }
 
+func (p *Panel) refreshLoop(done <-chan struct{}) {
+	var tick <-chan time.Time
+	if p.config.Texecom.RefreshInterval > 0 {
+		ticker := time.NewTicker(time.Duration(p.config.Texecom.RefreshInterval) * time.Second)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick:
+		case <-p.refresh:
+		}
+		if err := p.refreshLayout(); err != nil {
+			p.log.Error("Failed to refresh panel configuration: %v", err)
+		}
+	}
+}
+
+// refreshLayout re-reads the area and zone configuration, replaces the
+// current areas and zones with it and notifies listeners of the differences.
+// Known areas and zones keep their last state.
+func (p *Panel) refreshLayout() error {
+	areas, zones, err := p.fetchLayout()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	change := diffLayout(visibleAreas(p.areas), visibleZones(p.zones), visibleAreas(areas), visibleZones(zones))
+	for i := range areas {
+		if old := findArea(p.areas, areas[i].Number); old != nil {
+			areas[i].Status = old.Status
+			areas[i].PartArm = old.PartArm
+			areas[i].Flags = old.Flags
+		}
+	}
+	for i := range zones {
+		if old := findZone(p.zones, zones[i].Number); old != nil {
+			zones[i].Status = old.Status
+			zones[i].Flags = old.Flags
+		}
+	}
+	p.areas = areas
+	p.zones = zones
+	p.dirty = true
+	p.mu.Unlock()
+
+	if change.Empty() {
+		p.log.Debug("Panel configuration unchanged")
+		return nil
+	}
+
+	p.log.Info("Panel configuration changed: %s", change)
+	for _, c := range change.ChangedAreas {
+		p.log.Info("Area %d changed from %q to %q", c.New.Number, c.Old.Name, c.New.Name)
+	}
+	for _, c := range change.ChangedZones {
+		p.log.Info("Zone %d changed from %q (%s) to %q (%s)", c.New.Number, c.Old.Name, c.Old.Type, c.New.Name, c.New.Type)
+	}
+
+	p.notify(change)
+	return nil
+}
+
+// SetOverrides replaces the area and zone overrides of the panel. If the
+// panel is running its configuration is re-read so that they take effect
+// straight away, otherwise they are applied when it next starts.
+func (p *Panel) SetOverrides(areas []config.AreaConfig, zones []config.ZoneConfig) error {
+	p.mu.Lock()
+	p.config.Areas = areas
+	p.config.Zones = zones
+	p.mu.Unlock()
+
+	if p.ConnectionState() != types.ConnectionStateReady {
+		return nil
+	}
+	return p.refreshLayout()
+}
+
+func diffLayout(oldAreas []types.Area, oldZones []types.Zone, newAreas []types.Area, newZones []types.Zone) types.PanelChange {
+	var change types.PanelChange
+
+	for _, area := range newAreas {
+		old := findArea(oldAreas, area.Number)
+		switch {
+		case old == nil:
+			change.AddedAreas = append(change.AddedAreas, area)
+		case old.Name != area.Name:
+			change.ChangedAreas = append(change.ChangedAreas, types.AreaChange{Old: *old, New: area})
+		}
+	}
+	for _, area := range oldAreas {
+		if findArea(newAreas, area.Number) == nil {
+			change.RemovedAreas = append(change.RemovedAreas, area)
+		}
+	}
+
+	for _, zone := range newZones {
+		old := findZone(oldZones, zone.Number)
+		switch {
+		case old == nil:
+			change.AddedZones = append(change.AddedZones, zone)
+		case old.Name != zone.Name || old.Type != zone.Type:
+			change.ChangedZones = append(change.ChangedZones, types.ZoneChange{Old: *old, New: zone})
+		}
+	}
+	for _, zone := range oldZones {
+		if findZone(newZones, zone.Number) == nil {
+			change.RemovedZones = append(change.RemovedZones, zone)
+		}
+	}
+
+	return change
+}
+
+func findArea(areas []types.Area, number int) *types.Area {
+	for i := range areas {
+		if areas[i].Number == number {
+			return &areas[i]
+		}
+	}
+	return nil
+}
+
+func findZone(zones []types.Zone, number int) *types.Zone {
+	for i := range zones {
+		if zones[i].Number == number {
+			return &zones[i]
+		}
+	}
+	return nil
+}
+
+// refreshAreaStates polls the area flags and notifies listeners of any area
+// whose state or flags have changed since the last poll or event.
+func (p *Panel) refreshAreaStates() error {
+	states, err := p.texecom.GetAreaStates()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	var changed []interface{}
+	for i, state := range states {
+		if i >= len(p.areas) {
+			break
+		}
+		area := &p.areas[i]
+		if area.Status == state.Status && area.PartArm == state.PartArm && area.Flags == state.Flags {
+			continue
+		}
+		area.Status = state.Status
+		area.PartArm = state.PartArm
+		area.Flags = state.Flags
+		p.log.Info("Area %s (%d) status polled as %s", area.Name, area.Number, area.Status)
+		p.dirty = true
+		if !area.Ignored {
+			changed = append(changed, *area)
+		}
+	}
+	p.mu.Unlock()
+
+	p.notify(changed...)
+	return nil
+}
+
 func (p *Panel) updateZoneStates() error {
 	states, err := p.texecom.GetZoneStates()
 	if err != nil {
@@ -189,7 +579,8 @@ func (p *Panel) updateZoneStates() error {
 
 	for i, state := range states {
 		if i < len(p.zones) {
-			p.zones[i].Status = state
+			p.zones[i].Status = state.State
+			p.zones[i].Flags = state.ZoneFlags
 		}
 	}
 
@@ -206,12 +597,47 @@ func (p *Panel) updateAreaStates() error {
 		if i < len(p.areas) {
 			p.areas[i].Status = state.Status
 			p.areas[i].PartArm = state.PartArm
+			p.areas[i].Flags = state.Flags
 		}
 	}
 
 	return nil
 }
 
+func (p *Panel) setConnectionState(state types.ConnectionState) {
+	p.mu.Lock()
+	changed := p.connState != state
+	p.connState = state
+	p.mu.Unlock()
+
+	if changed {
+		p.notify(state)
+	}
+}
+
+// ConnectionState returns the state of the connection to the panel.
+func (p *Panel) ConnectionState() types.ConnectionState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connState
+}
+
+// Name returns the configured name of the panel.
+func (p *Panel) Name() string {
+	return p.config.Name
+}
+
+// LastMessage returns when the panel last sent anything, or the zero time if
+// it never has.
+func (p *Panel) LastMessage() time.Time {
+	return p.texecom.LastMessage()
+}
+
+// Done returns a channel that is closed when the panel connection is lost.
+func (p *Panel) Done() <-chan struct{} {
+	return p.texecom.Done()
+}
+
 func (p *Panel) Arm(area int, armType types.ArmType) error {
 	return p.texecom.Arm(area, armType)
 }
@@ -224,6 +650,10 @@ func (p *Panel) Reset(area int) error {
 	return p.texecom.Reset(area)
 }
 
+func (p *Panel) SetZoneBypass(zone int, bypass bool) error {
+	return p.texecom.SetZoneBypass(zone, bypass)
+}
+
 func (p *Panel) SetDateTime(t time.Time) error {
 	return p.texecom.SetDateTime(t)
 }
@@ -232,44 +662,52 @@ func (p *Panel) SetLCDDisplay(text string) error {
 	return p.texecom.SetLCDDisplay(text)
 }
 
+// GetAreas returns the areas that are not ignored by config.
 func (p *Panel) GetAreas() []types.Area {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return p.areas
+	return visibleAreas(p.areas)
 }
 
+// GetZones returns the zones that are not ignored by config.
 func (p *Panel) GetZones() []types.Zone {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return p.zones
+	return visibleZones(p.zones)
 }
 
-func (p *Panel) GetDevice() types.Device {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	return p.device
+func visibleAreas(areas []types.Area) []types.Area {
+	visible := make([]types.Area, 0, len(areas))
+	for _, area := range areas {
+		if !area.Ignored {
+			visible = append(visible, area)
+		}
+	}
+	return visible
 }
 
-func (p *Panel) SetCachedData(data *types.CacheData) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.device = data.Device
-	p.areas = data.Areas
-	p.zones = data.Zones
+func visibleZones(zones []types.Zone) []types.Zone {
+	visible := make([]types.Zone, 0, len(zones))
+	for _, zone := range zones {
+		if !zone.Ignored {
+			visible = append(visible, zone)
+		}
+	}
+	return visible
 }
 
-func (p *Panel) GetCacheableData() *types.CacheData {
-	return &types.CacheData{
-		Device:     p.device,
-		Areas:      p.areas,
-		Zones:      p.zones,
-		LastUpdate: time.Now(),
-	}
+func (p *Panel) GetDevice() types.Device {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.device
 }
 
 func (p *Panel) Disconnect() {
+	p.saveState()
 	p.log.Info("Disconnecting from panel...")
 	p.texecom.Disconnect()
+	p.isLoggedIn = false
+	p.setConnectionState(types.ConnectionStateDisconnected)
 	p.log.Info("Disconnected from panel")
 }
 
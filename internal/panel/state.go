@@ -0,0 +1,85 @@
+package panel
+
+import (
+	"time"
+
+	"github.com/daemonp/texecom2mqtt/internal/cache"
+	"github.com/daemonp/texecom2mqtt/internal/types"
+)
+
+// PersistState makes the panel save its state to the cache directory while it
+// runs, for RestoreState to load on the next start. It is independent of
+// EnableCache.
+func (p *Panel) PersistState() {
+	p.persistState = true
+}
+
+// RestoreState loads the areas, zones and last log event saved by the
+// previous run, so that the areas and zones can be published before the panel
+// is reached. The log event, already published by the previous run, is only
+// kept to recognise it if the panel sends it again.
+// It reports whether any state was restored. State is only kept once
+// PersistState has been called.
+func (p *Panel) RestoreState() bool {
+	if !p.persistState {
+		return false
+	}
+
+	state, err := cache.LoadState(p.config.Prefix)
+	if err != nil {
+		p.log.Warning("Failed to load last known state: %v", err)
+		return false
+	}
+	if state == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.areas) > 0 || len(p.zones) > 0 {
+		// Already read from the panel
+		return false
+	}
+	p.areas = state.Areas
+	p.zones = state.Zones
+	p.lastLogEvent = state.LastLogEvent
+	p.log.Info("Restored last known state of %d areas and %d zones from %s", len(state.Areas), len(state.Zones), state.Saved.Format(time.RFC3339))
+	return true
+}
+
+// saveState persists the current state if it changed since it was last
+// saved.
+func (p *Panel) saveState() {
+	if !p.persistState {
+		return
+	}
+
+	p.mu.Lock()
+	if !p.dirty {
+		p.mu.Unlock()
+		return
+	}
+	state := &types.PanelState{
+		Areas:        append([]types.Area(nil), p.areas...),
+		Zones:        append([]types.Zone(nil), p.zones...),
+		LastLogEvent: p.lastLogEvent,
+		Saved:        time.Now(),
+	}
+	p.dirty = false
+	p.mu.Unlock()
+
+	// Only warn once about a state that cannot be saved, such as on a read
+	// only file system
+	err := cache.SaveState(p.config.Prefix, state)
+	p.mu.Lock()
+	failedBefore := p.saveFailed
+	p.saveFailed = err != nil
+	p.mu.Unlock()
+
+	switch {
+	case err != nil && !failedBefore:
+		p.log.Warning("Failed to save last known state: %v", err)
+	case err != nil:
+		p.log.Debug("Failed to save last known state: %v", err)
+	}
+}
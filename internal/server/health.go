@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/daemonp/texecom2mqtt/internal/types"
+)
+
+// Health is the body of both health checks.
+type Health struct {
+	Status        string        `json:"status"`
+	MQTTConnected bool          `json:"mqtt_connected"`
+	Panels        []PanelHealth `json:"panels"`
+}
+
+type PanelHealth struct {
+	Name        string     `json:"name"`
+	Connection  string     `json:"connection"`
+	LoggedIn    bool       `json:"logged_in"`
+	LastMessage *time.Time `json:"last_message,omitempty"`
+	// LastMessageAge is in seconds. Before the panel has sent anything it
+	// is counted from the start of the bridge.
+	LastMessageAge float64 `json:"last_message_age"`
+	// Healthy is false once the panel has been silent for longer than
+	// max_message_age, whether or not it is thought to be connected.
+	Healthy bool `json:"healthy"`
+	// Ready is true while the panel is logged in, started and healthy.
+	Ready bool `json:"ready"`
+}
+
+func (s *Server) health() Health {
+	h := Health{MQTTConnected: s.broker.Connected(), Panels: make([]PanelHealth, 0, len(s.panels))}
+	maxAge := time.Duration(s.config.MaxMessageAge) * time.Second
+
+	for _, p := range s.panels {
+		state := p.ConnectionState()
+		ph := PanelHealth{
+			Name:       p.Name(),
+			Connection: state.String(),
+			LoggedIn:   state >= types.ConnectionStateLoggedIn,
+		}
+
+		since := s.started
+		if last := p.LastMessage(); !last.IsZero() {
+			ph.LastMessage = &last
+			since = last
+		}
+		age := time.Since(since)
+		ph.LastMessageAge = age.Seconds()
+		ph.Healthy = age <= maxAge
+		ph.Ready = ph.Healthy && state == types.ConnectionStateReady
+
+		h.Panels = append(h.Panels, ph)
+	}
+	return h
+}
+
+// handleHealthz fails while any panel has gone silent, which a restart may
+// fix.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	h := s.health()
+	ok := true
+	for _, p := range h.Panels {
+		ok = ok && p.Healthy
+	}
+	writeHealth(w, h, ok, "unhealthy")
+}
+
+// handleReadyz fails unless the broker is connected and every panel is
+// ready.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	h := s.health()
+	ok := h.MQTTConnected
+	for _, p := range h.Panels {
+		ok = ok && p.Ready
+	}
+	writeHealth(w, h, ok, "not_ready")
+}
+
+func writeHealth(w http.ResponseWriter, h Health, ok bool, failure string) {
+	code := http.StatusOK
+	h.Status = "ok"
+	if !ok {
+		code = http.StatusServiceUnavailable
+		h.Status = failure
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(h)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/daemonp/texecom2mqtt/internal/config"
+	"github.com/daemonp/texecom2mqtt/internal/log"
+	"github.com/daemonp/texecom2mqtt/internal/types"
+)
+
+// Panel is what the server needs to know about a panel.
+type Panel interface {
+	Name() string
+	ConnectionState() types.ConnectionState
+	LastMessage() time.Time
+}
+
+// Broker is the MQTT connection shared by the panels.
+type Broker interface {
+	Connected() bool
+}
+
+// Server is the local HTTP listener serving the health checks, and anything
+// else registered with Handle.
+type Server struct {
+	config  *config.HTTPConfig
+	broker  Broker
+	panels  []Panel
+	started time.Time
+	mux     *http.ServeMux
+	server  *http.Server
+	log     *log.Logger
+}
+
+func New(cfg *config.HTTPConfig, broker Broker, panels []Panel, logger *log.Logger) *Server {
+	s := &Server{
+		config:  cfg,
+		broker:  broker,
+		panels:  panels,
+		started: time.Now(),
+		mux:     http.NewServeMux(),
+		log:     logger,
+	}
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	return s
+}
+
+// Handle serves handler on pattern alongside the health checks.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start listens on the configured address and serves in the background. It
+// fails if the address cannot be listened on.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.config.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.config.Listen, err)
+	}
+
+	s.server = &http.Server{Handler: s.mux, ReadHeaderTimeout: 10 * time.Second}
+	s.log.Info("Serving HTTP on %s", listener.Addr())
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Error("HTTP server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (s *Server) Close() {
+	if s.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+}
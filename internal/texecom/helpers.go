@@ -8,24 +8,54 @@ import (
 )
 
 type ZoneBitmapData struct {
-	State          types.ZoneState
-	Fault          bool
-	FailedTest     bool
-	Alarmed        bool
-	ManualBypassed bool
-	AutoBypassed   bool
-	Masked         bool
+	State types.ZoneState
+	types.ZoneFlags
 }
 
 func ParseZoneBitmap(zoneBitmap byte) ZoneBitmapData {
 	return ZoneBitmapData{
-		State:          types.ZoneState(zoneBitmap & 0x3),
-		Fault:          (zoneBitmap & (1 << 2)) != 0,
-		FailedTest:     (zoneBitmap & (1 << 3)) != 0,
-		Alarmed:        (zoneBitmap & (1 << 4)) != 0,
-		ManualBypassed: (zoneBitmap & (1 << 5)) != 0,
-		AutoBypassed:   (zoneBitmap & (1 << 6)) != 0,
-		Masked:         (zoneBitmap & (1 << 7)) != 0,
+		State: types.ZoneState(zoneBitmap & 0x3),
+		ZoneFlags: types.ZoneFlags{
+			Fault:          (zoneBitmap & (1 << 2)) != 0,
+			FailedTest:     (zoneBitmap & (1 << 3)) != 0,
+			Alarmed:        (zoneBitmap & (1 << 4)) != 0,
+			ManualBypassed: (zoneBitmap & (1 << 5)) != 0,
+			AutoBypassed:   (zoneBitmap & (1 << 6)) != 0,
+			Masked:         (zoneBitmap & (1 << 7)) != 0,
+		},
+	}
+}
+
+func ParseAreaFlags(flags uint64) types.AreaFlags {
+	bit := func(n uint) bool { return flags&(1<<n) != 0 }
+	return types.AreaFlags{
+		Raw:            flags,
+		Alarm:          bit(types.AreaFlagAlarm),
+		FireAlarm:      bit(types.AreaFlagFireAlarm),
+		PAAlarm:        bit(types.AreaFlagPAAlarm),
+		MedicalAlarm:   bit(types.AreaFlagMedicalAlarm),
+		TamperAlarm:    bit(types.AreaFlagTamperAlarm),
+		TechnicalAlarm: bit(types.AreaFlagTechnicalAlarm),
+		DuressAlarm:    bit(types.AreaFlagDuressAlarm),
+		ConfirmedAlarm: bit(types.AreaFlagConfirmedAlarm),
+		AlarmAborted:   bit(types.AreaFlagAlarmAborted),
+		BellActive:     bit(types.AreaFlagBellActive),
+		StrobeActive:   bit(types.AreaFlagStrobeActive),
+		EntryTimer:     bit(types.AreaFlagEntryTimer),
+		ExitTimer:      bit(types.AreaFlagExitTimer),
+		ExitFault:      bit(types.AreaFlagExitFault),
+		ArmFailed:      bit(types.AreaFlagArmFailed),
+		ReadyToArm:     bit(types.AreaFlagReadyToArm),
+		ZonesOmitted:   bit(types.AreaFlagZonesOmitted),
+		ResetRequired:  bit(types.AreaFlagResetRequired),
+		EngineerReset:  bit(types.AreaFlagEngineerReset),
+		LineFault:      bit(types.AreaFlagLineFault),
+		FullArmed:      bit(types.AreaFlagFullArmed),
+		PartArmed:      bit(types.AreaFlagPartArmed),
+		Armed:          bit(types.AreaFlagArmed),
+		PartArm1:       bit(types.AreaFlagPartArm1),
+		PartArm2:       bit(types.AreaFlagPartArm2),
+		PartArm3:       bit(types.AreaFlagPartArm3),
 	}
 }
 
@@ -3,9 +3,11 @@ package texecom
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/daemonp/texecom2mqtt/internal/log"
@@ -25,14 +27,99 @@ type Texecom struct {
 	eventChan      chan interface{}
 	isConnected    bool
 	disconnectChan chan struct{}
-}
+	// closeOnce tears down the current connection exactly once
+	closeOnce *sync.Once
+	// eventsClosed is set, under mu, once the read loop has closed eventChan
+	eventsClosed bool
+	serialNumber string
+	readOnly     bool
+	observer     Observer
+	// lastMessage is when the panel last sent anything, in Unix nanoseconds
+	lastMessage atomic.Int64
+}
+
+// ErrReadOnly is returned for commands that could change the state of a
+// panel while it is read only.
+var ErrReadOnly = errors.New("panel is read only")
+
+// readCommands are the only commands sent to a read only panel. Anything
+// else, such as arming, bypassing zones or setting outputs, the time or the
+// LCD text, is refused.
+var readCommands = map[byte]bool{
+	0x01: true, // Login
+	0x02: true, // Get Zone State
+	0x03: true, // Get Zone Details
+	0x0B: true, // Get Area Flags
+	0x16: true, // Get Panel Identification
+	0x19: true, // Get System Power
+	0x22: true, // Get Area Text
+}
+
+// Observer is told about the traffic with the panel, such as to keep metrics.
+type Observer interface {
+	// CommandDone is called with the command byte, formatted as 0x16, and
+	// the time the panel took to answer it.
+	CommandDone(command string, duration time.Duration)
+	CRCError()
+	// Event is called with the type of each event received: zone, area, log
+	// or unknown.
+	Event(eventType string)
+}
+
+type nopObserver struct{}
+
+func (nopObserver) CommandDone(string, time.Duration) {}
+func (nopObserver) CRCError()                         {}
+func (nopObserver) Event(string)                      {}
 
 func NewTexecom(logger *log.Logger) *Texecom {
 	return &Texecom{
 		log:            logger,
 		eventChan:      make(chan interface{}, 100),
 		disconnectChan: make(chan struct{}),
+		observer:       nopObserver{},
+	}
+}
+
+// SetReadOnly stops the panel from being sent any command that could change
+// its state.
+func (t *Texecom) SetReadOnly(readOnly bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.readOnly = readOnly
+}
+
+// allowed reports whether packet may be sent, logging the commands refused
+// by a read only panel. t.mu must be held.
+func (t *Texecom) allowed(packet []byte) bool {
+	if t.readOnly && !readCommands[commandByte(packet)] {
+		t.log.Warn("Refusing to send command 0x%02X to a read only panel", commandByte(packet))
+		return false
 	}
+	return true
+}
+
+// SetObserver makes o observe the traffic with the panel. It must be called
+// before connecting.
+func (t *Texecom) SetObserver(o Observer) {
+	t.observer = o
+}
+
+// observeCommand reports how long the panel took to answer packet.
+func (t *Texecom) observeCommand(packet []byte, start time.Time) {
+	t.observer.CommandDone(fmt.Sprintf("0x%02X", commandByte(packet)), time.Since(start))
+}
+
+// commandByte returns the command of packet, which is either a framed
+// command packet or a bare command followed by its body.
+func commandByte(packet []byte) byte {
+	if len(packet) > 4 && packet[0] == 't' && packet[1] == 'C' {
+		return packet[4]
+	}
+	if len(packet) == 0 {
+		return 0
+	}
+	return packet[0]
 }
 
 const (
@@ -53,6 +140,11 @@ func (t *Texecom) Connect(host string, port int) error {
 	}
 
 	t.conn = conn
+	t.eventChan = make(chan interface{}, 100)
+	t.disconnectChan = make(chan struct{})
+	t.closeOnce = &sync.Once{}
+	t.eventsClosed = false
+	t.isLoggedIn = false
 	t.isConnected = true // Set this flag
 	t.log.Debug("Connection established")
 
@@ -64,13 +156,14 @@ func (t *Texecom) Connect(host string, port int) error {
 		return fmt.Errorf("failed to get serial number: %v", err)
 	}
 	t.log.Info("Retrieved serial number: %s", serialNumber)
+	t.serialNumber = serialNumber
 
 	// Check connection status after getting serial number
 	if !t.isConnected {
 		return fmt.Errorf("connection lost after retrieving serial number")
 	}
 
-	go t.readLoop()
+	go t.readLoop(t.conn, t.eventChan, t.disconnectChan)
 
 	return nil
 }
@@ -79,22 +172,28 @@ func (t *Texecom) Disconnect() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if !t.isConnected {
+	if t.closeOnce == nil {
 		return
 	}
 
-	if t.conn != nil {
-		t.conn.Close()
-	}
-
 	t.log.Debug("Disconnecting from panel")
-	close(t.disconnectChan)
-	t.conn.Close()
-	t.isConnected = false
-	close(t.eventChan)
+	t.closeLink()
 	t.log.Debug("Disconnected from panel")
 }
 
+// closeLink ends the current connection however it was lost, and is safe to
+// call any number of times. Closing the Done channel stops the read loop,
+// which closes the events channel as it exits. The caller must hold t.mu.
+func (t *Texecom) closeLink() {
+	t.isConnected = false
+	t.closeOnce.Do(func() {
+		close(t.disconnectChan)
+		if t.conn != nil {
+			t.conn.Close()
+		}
+	})
+}
+
 func (t *Texecom) Login(password string) error {
 	if !t.isConnected {
 		return fmt.Errorf("not connected to panel")
@@ -173,11 +272,16 @@ func (t *Texecom) sendCommand(packet []byte) ([]byte, error) {
 		return nil, fmt.Errorf("not connected")
 	}
 
+	if !t.allowed(packet) {
+		return nil, ErrReadOnly
+	}
+
 	t.log.Debug("Sending command: %x", packet)
+	start := time.Now()
 	_, err := t.conn.Write(packet)
 	if err != nil {
 		t.log.Error("Failed to send command: %v", err)
-		t.isConnected = false
+		t.closeLink()
 		return nil, fmt.Errorf("failed to send command: %v", err)
 	}
 
@@ -192,15 +296,23 @@ func (t *Texecom) sendCommand(packet []byte) ([]byte, error) {
 				continue // This is just a timeout for this read attempt, not for the whole operation
 			}
 			t.log.Error("Failed to read response: %v", err)
-			t.isConnected = false
+			t.closeLink()
 			return nil, fmt.Errorf("failed to read response: %v", err)
 		}
 
+		t.markMessage()
 		t.log.Debug("Received response: %x", resp[:n])
 		if n >= 3 && resp[0] == 't' && resp[1] == 'R' {
+			t.observeCommand(packet, start)
 			return resp[:n], nil
 		}
-		t.processMessage(resp[:n])
+		// The read loop cannot close the events channel while t.mu is held
+		if event := t.processMessage(resp[:n]); event != nil && !t.eventsClosed {
+			select {
+			case t.eventChan <- event:
+			case <-t.disconnectChan:
+			}
+		}
 	}
 
 	return nil, fmt.Errorf("command timed out")
@@ -240,7 +352,12 @@ func (t *Texecom) sendCommandWithTimeout(packet []byte, timeout time.Duration) (
 		return nil, fmt.Errorf("not connected")
 	}
 
+	if !t.allowed(packet) {
+		return nil, ErrReadOnly
+	}
+
 	t.log.Debug("Sending command: %v", packet)
+	start := time.Now()
 	_, err := t.conn.Write(packet)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send command: %v", err)
@@ -258,6 +375,7 @@ func (t *Texecom) sendCommandWithTimeout(packet []byte, timeout time.Duration) (
 		return nil, fmt.Errorf("failed to read response: %v", err)
 	}
 
+	t.observeCommand(packet, start)
 	return resp[:n], nil
 }
 
@@ -337,7 +455,7 @@ func (t *Texecom) GetAllZones() ([]types.Zone, error) {
 	return zones, nil
 }
 
-func (t *Texecom) GetZoneStates() ([]types.ZoneState, error) {
+func (t *Texecom) GetZoneStates() ([]ZoneBitmapData, error) {
 	t.log.Debug("Sending Get Zone State command")
 	cmd := []byte{0x02} // Get Zone State command
 	resp, err := t.sendCommand(cmd)
@@ -347,9 +465,9 @@ func (t *Texecom) GetZoneStates() ([]types.ZoneState, error) {
 	}
 
 	t.log.Debug("Parsing zone states")
-	var states []types.ZoneState
+	var states []ZoneBitmapData
 	for _, b := range resp {
-		states = append(states, types.ZoneState(b&0x03))
+		states = append(states, ParseZoneBitmap(b))
 	}
 
 	t.log.Debug("Retrieved states for %d zones", len(states))
@@ -368,12 +486,13 @@ func (t *Texecom) GetAreaStates() ([]types.AreaStatus, error) {
 	t.log.Debug("Parsing area states")
 	var states []types.AreaStatus
 	for i := 0; i < len(resp); i += 8 {
-		flags := binary.LittleEndian.Uint64(resp[i : i+8])
-		status := types.AreaStatus{
-			Status:  t.parseAreaState(flags),
-			PartArm: t.parsePartArm(flags),
-		}
-		states = append(states, status)
+		flags := ParseAreaFlags(binary.LittleEndian.Uint64(resp[i : i+8]))
+		state, partArm := flags.State()
+		states = append(states, types.AreaStatus{
+			Status:  state,
+			PartArm: partArm,
+			Flags:   flags,
+		})
 	}
 
 	t.log.Debug("Retrieved states for %d areas", len(states))
@@ -434,6 +553,28 @@ func (t *Texecom) Reset(areaNumber int) error {
 	return nil
 }
 
+func (t *Texecom) SetZoneBypass(zoneNumber int, bypass bool) error {
+	t.log.Debug("Sending Set Zone Bypass command for zone %d, bypass %v", zoneNumber, bypass)
+	state := byte(0)
+	if bypass {
+		state = 1
+	}
+	cmd := []byte{0x12, byte(zoneNumber), byte(zoneNumber >> 8), state} // Set Zone Bypass command
+	resp, err := t.sendCommand(cmd)
+	if err != nil {
+		t.log.Error("Failed to set zone bypass: %v", err)
+		return fmt.Errorf("failed to set zone bypass: %v", err)
+	}
+
+	if resp[0] != 0x06 { // ACK
+		t.log.Error("Failed to set zone bypass: invalid response")
+		return fmt.Errorf("failed to set zone bypass: invalid response")
+	}
+
+	t.log.Debug("Zone bypass set successfully")
+	return nil
+}
+
 func (t *Texecom) SetDateTime(datetime time.Time) error {
 	t.log.Debug("Sending Set Date/Time command for %v", datetime)
 	cmd := []byte{0x18} // Set Date/Time command
@@ -490,31 +631,74 @@ func (t *Texecom) UpdateSystemPower() error {
 	return nil
 }
 
+func (t *Texecom) markMessage() {
+	t.lastMessage.Store(time.Now().UnixNano())
+}
+
+// LastMessage returns when the panel last sent anything, or the zero time if
+// it never has.
+func (t *Texecom) LastMessage() time.Time {
+	nanos := t.lastMessage.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
 func (t *Texecom) Events() <-chan interface{} {
 	return t.eventChan
 }
 
-func (t *Texecom) readLoop() {
+// Done returns a channel that is closed when the current connection ends.
+func (t *Texecom) Done() <-chan struct{} {
+	return t.disconnectChan
+}
+
+// SerialNumber returns the serial number reported when connecting.
+func (t *Texecom) SerialNumber() string {
+	return t.serialNumber
+}
+
+// readLoop reads events from conn until the connection ends, and is the
+// only one to close events.
+func (t *Texecom) readLoop(conn net.Conn, events chan interface{}, done chan struct{}) {
+	defer func() {
+		t.mu.Lock()
+		t.eventsClosed = true
+		close(events)
+		t.mu.Unlock()
+	}()
+
 	buffer := make([]byte, 1024)
 	for {
 		select {
-		case <-t.disconnectChan:
+		case <-done:
 			return
 		default:
-			t.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-			n, err := t.conn.Read(buffer)
-			t.conn.SetReadDeadline(time.Time{}) // Reset the deadline
+			conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+			n, err := conn.Read(buffer)
+			conn.SetReadDeadline(time.Time{}) // Reset the deadline
 			if err != nil {
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 					// This is a timeout, which is expected when there's no data
 					continue
 				}
-				t.log.Error("Read error: %v", err)
+				select {
+				case <-done:
+				default:
+					t.log.Error("Read error: %v", err)
+				}
 				t.Disconnect()
 				return
 			}
 
-			t.processMessage(buffer[:n])
+			t.markMessage()
+			if event := t.processMessage(buffer[:n]); event != nil {
+				select {
+				case events <- event:
+				case <-done:
+				}
+			}
 		}
 	}
 }
@@ -542,24 +726,29 @@ func (t *Texecom) validateCrc(message []byte) bool {
 	return t.calculateCRC(message[:len(message)-1]) == crc
 }
 
-func (t *Texecom) processMessage(msg []byte) {
+// processMessage returns the event carried by msg, or nil if it carries
+// none.
+func (t *Texecom) processMessage(msg []byte) interface{} {
 	t.log.Debug("Processing message: %v", msg)
 	if len(msg) < 5 {
-		return
+		return nil
 	}
 
 	if !t.validateCrc(msg) {
 		t.log.Error("Invalid CRC for message: %x", msg)
-		return
+		t.observer.CRCError()
+		return nil
 	}
 
 	switch msg[1] {
 	case 'M': // Event message
 		event := t.parseEvent(msg[4:])
-		t.eventChan <- event
+		t.observer.Event(eventType(event))
+		return event
 	case 'R': // Response message
 		t.log.Debug("Received response message: %v", msg)
 	}
+	return nil
 }
 
 func (t *Texecom) parseEvent(data []byte) interface{} {
@@ -584,10 +773,26 @@ func (t *Texecom) parseEvent(data []byte) interface{} {
 	}
 }
 
+// eventType names the type of a parsed event for the observer.
+func eventType(event interface{}) string {
+	switch event.(type) {
+	case types.ZoneEvent:
+		return "zone"
+	case types.AreaEvent:
+		return "area"
+	case types.LogEvent:
+		return "log"
+	default:
+		return "unknown"
+	}
+}
+
 func (t *Texecom) parseZoneEvent(data []byte) types.ZoneEvent {
+	bitmap := ParseZoneBitmap(data[2])
 	event := types.ZoneEvent{
 		ZoneNumber: int(binary.LittleEndian.Uint16(data[:2])),
-		ZoneState:  types.ZoneState(data[2] & 0x03),
+		ZoneState:  bitmap.State,
+		Flags:      bitmap.ZoneFlags,
 	}
 	t.log.Debug("Parsed Zone Event: %+v", event)
 	return event
@@ -627,29 +832,6 @@ func (t *Texecom) parseTimestamp(data []byte) time.Time {
 	return time.Date(int(year), time.Month(month), int(day), int(hours), int(minutes), int(seconds), 0, time.UTC)
 }
 
-func (t *Texecom) parseAreaState(flags uint64) types.AreaState {
-	if flags&(1<<0) != 0 {
-		return types.AreaStateInAlarm
-	}
-	if flags&(1<<21) != 0 || flags&(1<<22) != 0 || flags&(1<<23) != 0 {
-		return types.AreaStateArmed
-	}
-	return types.AreaStateDisarmed
-}
-
-func (t *Texecom) parsePartArm(flags uint64) int {
-	if flags&(1<<50) != 0 {
-		return 1
-	}
-	if flags&(1<<51) != 0 {
-		return 2
-	}
-	if flags&(1<<52) != 0 {
-		return 3
-	}
-	return 0
-}
-
 func (t *Texecom) calculateCRC(data []byte) byte {
 	crc := byte(0xFF)
 	for _, b := range data {
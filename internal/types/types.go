@@ -1,7 +1,9 @@
 package types
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -13,11 +15,28 @@ type Device struct {
 }
 
 type Area struct {
-	Number  int
-	Name    string
-	ID      string
-	Status  AreaState
-	PartArm int
+	Number        int
+	Name          string
+	ID            string
+	Status        AreaState
+	PartArm       int
+	Flags         AreaFlags
+	Ignored       bool
+	HomeAssistant *HomeAssistantArea
+}
+
+// HomeAssistantArea maps the panel's arm types to Home Assistant arm modes
+// and holds the alarm_control_panel code settings.
+type HomeAssistantArea struct {
+	FullArm            string `yaml:"full_arm"`
+	PartArm1           string `yaml:"part_arm_1"`
+	PartArm2           string `yaml:"part_arm_2"`
+	PartArm3           string `yaml:"part_arm_3"`
+	Code               string `yaml:"code"`
+	CodeArmRequired    bool   `yaml:"code_arm_required"`
+	CodeDisarmRequired bool   `yaml:"code_disarm_required"`
+	Icon               string `yaml:"icon"`
+	Hidden             bool   `yaml:"hidden"`
 }
 
 type Zone struct {
@@ -26,21 +45,132 @@ type Zone struct {
 	Type          ZoneType
 	ID            string
 	Status        ZoneState
+	Flags         ZoneFlags
+	Ignored       bool
 	HomeAssistant *HomeAssistantZone
 }
 
+// ZoneFlags are the condition bits of a zone bitmap besides its state.
+type ZoneFlags struct {
+	Fault          bool
+	FailedTest     bool
+	Alarmed        bool
+	ManualBypassed bool
+	AutoBypassed   bool
+	Masked         bool
+}
+
+// Bypassed reports whether the zone is bypassed, manually or automatically.
+func (f ZoneFlags) Bypassed() bool {
+	return f.ManualBypassed || f.AutoBypassed
+}
+
+// Problem reports whether the zone has a fault, failed its test or is masked.
+func (f ZoneFlags) Problem() bool {
+	return f.Fault || f.FailedTest || f.Masked
+}
+
 type HomeAssistantZone struct {
 	DeviceClass string `yaml:"device_class"`
+	Icon        string `yaml:"icon"`
+	Hidden      bool   `yaml:"hidden"`
 }
 
 type AreaStatus struct {
 	Status  AreaState
 	PartArm int
+	Flags   AreaFlags
+}
+
+// AreaFlags is the decoded form of the 64-bit flag word returned by the
+// Get Area Flags command. Raw holds the whole word, including the bits that
+// are not decoded: 11, 24 to 49 and 53 to 63.
+type AreaFlags struct {
+	Raw uint64 `json:"raw"`
+
+	Alarm          bool `json:"alarm"`
+	FireAlarm      bool `json:"fire_alarm"`
+	PAAlarm        bool `json:"pa_alarm"`
+	MedicalAlarm   bool `json:"medical_alarm"`
+	TamperAlarm    bool `json:"tamper_alarm"`
+	TechnicalAlarm bool `json:"technical_alarm"`
+	DuressAlarm    bool `json:"duress_alarm"`
+	ConfirmedAlarm bool `json:"confirmed_alarm"`
+	AlarmAborted   bool `json:"alarm_aborted"`
+	BellActive     bool `json:"bell_active"`
+	StrobeActive   bool `json:"strobe_active"`
+	EntryTimer     bool `json:"entry_timer"`
+	ExitTimer      bool `json:"exit_timer"`
+	ExitFault      bool `json:"exit_fault"`
+	ArmFailed      bool `json:"arm_failed"`
+	ReadyToArm     bool `json:"ready_to_arm"`
+	ZonesOmitted   bool `json:"zones_omitted"`
+	ResetRequired  bool `json:"reset_required"`
+	EngineerReset  bool `json:"engineer_reset"`
+	LineFault      bool `json:"line_fault"`
+	FullArmed      bool `json:"full_armed"`
+	PartArmed      bool `json:"part_armed"`
+	Armed          bool `json:"armed"`
+	PartArm1       bool `json:"part_arm_1"`
+	PartArm2       bool `json:"part_arm_2"`
+	PartArm3       bool `json:"part_arm_3"`
 }
 
+// Fields returns every decoded flag by its JSON name.
+func (f AreaFlags) Fields() map[string]bool {
+	var values map[string]interface{}
+	data, _ := json.Marshal(f)
+	json.Unmarshal(data, &values)
+
+	fields := make(map[string]bool)
+	for name, value := range values {
+		if set, ok := value.(bool); ok {
+			fields[name] = set
+		}
+	}
+	return fields
+}
+
+// RawHex formats the whole flag word, for consumers of the bits that are not
+// decoded.
+func (f AreaFlags) RawHex() string {
+	return fmt.Sprintf("0x%016X", f.Raw)
+}
+
+// Bits of the area flag word.
+const (
+	AreaFlagAlarm          = 0
+	AreaFlagFireAlarm      = 1
+	AreaFlagPAAlarm        = 2
+	AreaFlagMedicalAlarm   = 3
+	AreaFlagTamperAlarm    = 4
+	AreaFlagTechnicalAlarm = 5
+	AreaFlagDuressAlarm    = 6
+	AreaFlagConfirmedAlarm = 7
+	AreaFlagAlarmAborted   = 8
+	AreaFlagBellActive     = 9
+	AreaFlagStrobeActive   = 10
+	AreaFlagEntryTimer     = 12
+	AreaFlagExitTimer      = 13
+	AreaFlagExitFault      = 14
+	AreaFlagArmFailed      = 15
+	AreaFlagReadyToArm     = 16
+	AreaFlagZonesOmitted   = 17
+	AreaFlagResetRequired  = 18
+	AreaFlagEngineerReset  = 19
+	AreaFlagLineFault      = 20
+	AreaFlagFullArmed      = 21
+	AreaFlagPartArmed      = 22
+	AreaFlagArmed          = 23
+	AreaFlagPartArm1       = 50
+	AreaFlagPartArm2       = 51
+	AreaFlagPartArm3       = 52
+)
+
 type ZoneEvent struct {
 	ZoneNumber int
 	ZoneState  ZoneState
+	Flags      ZoneFlags
 }
 
 type AreaEvent struct {
@@ -49,6 +179,78 @@ type AreaEvent struct {
 	PartArm    int
 }
 
+// State derives the overall area state from the decoded flags. Alarm takes
+// priority over the entry and exit timers, which in turn take priority over
+// the armed bits.
+func (f AreaFlags) State() (AreaState, int) {
+	switch {
+	case f.Alarm || f.FireAlarm || f.PAAlarm || f.MedicalAlarm || f.TamperAlarm || f.DuressAlarm:
+		return AreaStateInAlarm, 0
+	case f.EntryTimer:
+		return AreaStateInEntry, 0
+	case f.ExitTimer:
+		return AreaStateInExit, 0
+	case f.PartArm1:
+		return AreaStatePartArmed, 1
+	case f.PartArm2:
+		return AreaStatePartArmed, 2
+	case f.PartArm3:
+		return AreaStatePartArmed, 3
+	case f.PartArmed:
+		return AreaStatePartArmed, 0
+	case f.FullArmed || f.Armed:
+		return AreaStateArmed, 0
+	}
+	return AreaStateDisarmed, 0
+}
+
+// PanelChange describes how the areas and zones read back from the panel
+// differ from the ones currently in use.
+type PanelChange struct {
+	AddedAreas   []Area
+	RemovedAreas []Area
+	ChangedAreas []AreaChange
+	AddedZones   []Zone
+	RemovedZones []Zone
+	ChangedZones []ZoneChange
+}
+
+type AreaChange struct {
+	Old Area
+	New Area
+}
+
+type ZoneChange struct {
+	Old Zone
+	New Zone
+}
+
+// Empty reports whether the change contains no differences.
+func (c PanelChange) Empty() bool {
+	return len(c.AddedAreas) == 0 && len(c.RemovedAreas) == 0 && len(c.ChangedAreas) == 0 &&
+		len(c.AddedZones) == 0 && len(c.RemovedZones) == 0 && len(c.ChangedZones) == 0
+}
+
+// String summarises the change for logging.
+func (c PanelChange) String() string {
+	var parts []string
+	add := func(n int, what string) {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, what))
+		}
+	}
+	add(len(c.AddedAreas), "area(s) added")
+	add(len(c.RemovedAreas), "area(s) removed")
+	add(len(c.ChangedAreas), "area(s) changed")
+	add(len(c.AddedZones), "zone(s) added")
+	add(len(c.RemovedZones), "zone(s) removed")
+	add(len(c.ChangedZones), "zone(s) changed")
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
 type LogEvent struct {
 	Type        LogEventType
 	GroupType   LogEventGroupType
@@ -97,6 +299,29 @@ const (
 	AreaStateInAlarm
 )
 
+// ConnectionState is the state of the bridge's connection to a panel.
+type ConnectionState int
+
+const (
+	ConnectionStateDisconnected ConnectionState = iota
+	ConnectionStateConnecting
+	ConnectionStateConnected
+	ConnectionStateLoggedIn
+	ConnectionStateReady
+)
+
+var ConnectionStateDescriptions = map[ConnectionState]string{
+	ConnectionStateDisconnected: "disconnected",
+	ConnectionStateConnecting:   "connecting",
+	ConnectionStateConnected:    "connected",
+	ConnectionStateLoggedIn:     "logged_in",
+	ConnectionStateReady:        "ready",
+}
+
+func (s ConnectionState) String() string {
+	return ConnectionStateDescriptions[s]
+}
+
 type ArmType int
 
 const (
@@ -142,13 +367,61 @@ const (
 	LogEventGroupTypeClose
 )
 
+var LogEventGroupTypeDescriptions = map[LogEventGroupType]string{
+	LogEventGroupTypeNotReported:          "not_reported",
+	LogEventGroupTypePriorityAlarm:        "priority_alarm",
+	LogEventGroupTypePriorityAlarmRestore: "priority_alarm_restore",
+	LogEventGroupTypeAlarm:                "alarm",
+	LogEventGroupTypeRestore:              "restore",
+	LogEventGroupTypeOpen:                 "open",
+	LogEventGroupTypeClose:                "close",
+}
+
+func (g LogEventGroupType) String() string {
+	if description, ok := LogEventGroupTypeDescriptions[g]; ok {
+		return description
+	}
+	return fmt.Sprintf("group_%d", int(g))
+}
+
+// AreaNumbers decodes the areas bitmask of the event into area numbers.
+func (e LogEvent) AreaNumbers() []int {
+	var numbers []int
+	for i := 0; i < 16; i++ {
+		if e.Areas&(1<<i) != 0 {
+			numbers = append(numbers, i+1)
+		}
+	}
+	return numbers
+}
+
+// ZoneNumber returns the zone the event refers to, or 0 if it is not a zone
+// event. The parameter of zone alarm events is the zone number.
+func (e LogEvent) ZoneNumber() int {
+	if e.Type >= LogEventTypeEntryExit1 && e.Type <= LogEventTamper {
+		return int(e.Parameter)
+	}
+	return 0
+}
+
 type CacheData struct {
+	Version    int       `json:"version"`
 	Device     Device    `json:"device"`
 	Areas      []Area    `json:"areas"`
 	Zones      []Zone    `json:"zones"`
 	LastUpdate time.Time `json:"last_update"`
 }
 
+// PanelState is the last known state of a panel, persisted so that it can be
+// published on startup before the panel is reached.
+type PanelState struct {
+	Version      int       `json:"version"`
+	Areas        []Area    `json:"areas"`
+	Zones        []Zone    `json:"zones"`
+	LastLogEvent *LogEvent `json:"last_log_event,omitempty"`
+	Saved        time.Time `json:"saved"`
+}
+
 func (t ZoneType) String() string {
 	return ZoneTypeDescriptions[t]
 }
@@ -0,0 +1,93 @@
+package types_test
+
+import (
+	"testing"
+
+	"github.com/daemonp/texecom2mqtt/internal/texecom"
+	"github.com/daemonp/texecom2mqtt/internal/types"
+)
+
+func bits(n ...uint) uint64 {
+	var word uint64
+	for _, b := range n {
+		word |= 1 << b
+	}
+	return word
+}
+
+func TestParseAreaFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		word  uint64
+		field string
+	}{
+		{"alarm", bits(types.AreaFlagAlarm), "alarm"},
+		{"fire alarm", bits(types.AreaFlagFireAlarm), "fire_alarm"},
+		{"PA alarm", bits(types.AreaFlagPAAlarm), "pa_alarm"},
+		{"tamper alarm", bits(types.AreaFlagTamperAlarm), "tamper_alarm"},
+		{"strobe", bits(types.AreaFlagStrobeActive), "strobe_active"},
+		{"entry timer", bits(types.AreaFlagEntryTimer), "entry_timer"},
+		{"exit timer", bits(types.AreaFlagExitTimer), "exit_timer"},
+		{"exit fault", bits(types.AreaFlagExitFault), "exit_fault"},
+		{"ready to arm", bits(types.AreaFlagReadyToArm), "ready_to_arm"},
+		{"line fault", bits(types.AreaFlagLineFault), "line_fault"},
+		{"armed", bits(types.AreaFlagArmed), "armed"},
+		{"part arm 1", bits(types.AreaFlagPartArm1), "part_arm_1"},
+		{"part arm 3", bits(types.AreaFlagPartArm3), "part_arm_3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := texecom.ParseAreaFlags(tt.word)
+			if flags.Raw != tt.word {
+				t.Errorf("Raw = %#x, want %#x", flags.Raw, tt.word)
+			}
+			for name, set := range flags.Fields() {
+				if set != (name == tt.field) {
+					t.Errorf("%s = %v", name, set)
+				}
+			}
+		})
+	}
+}
+
+func TestParseAreaFlagsKeepsUndecodedBits(t *testing.T) {
+	word := bits(11, 24, 49, 53, 63)
+	flags := texecom.ParseAreaFlags(word)
+	for name, set := range flags.Fields() {
+		if set {
+			t.Errorf("undecoded bit set %s", name)
+		}
+	}
+	if got, want := flags.RawHex(), "0x8022000001000800"; got != want {
+		t.Errorf("RawHex() = %s, want %s", got, want)
+	}
+}
+
+func TestAreaFlagsState(t *testing.T) {
+	tests := []struct {
+		name    string
+		word    uint64
+		state   types.AreaState
+		partArm int
+	}{
+		{"disarmed", 0, types.AreaStateDisarmed, 0},
+		{"ready to arm only", bits(types.AreaFlagReadyToArm), types.AreaStateDisarmed, 0},
+		{"armed", bits(types.AreaFlagArmed), types.AreaStateArmed, 0},
+		{"full armed", bits(types.AreaFlagFullArmed), types.AreaStateArmed, 0},
+		{"part armed", bits(types.AreaFlagPartArmed), types.AreaStatePartArmed, 0},
+		{"part arm 2 over armed", bits(types.AreaFlagArmed, types.AreaFlagPartArmed, types.AreaFlagPartArm2), types.AreaStatePartArmed, 2},
+		{"exit over part arm", bits(types.AreaFlagExitTimer, types.AreaFlagPartArm1), types.AreaStateInExit, 0},
+		{"entry over exit", bits(types.AreaFlagEntryTimer, types.AreaFlagExitTimer, types.AreaFlagArmed), types.AreaStateInEntry, 0},
+		{"alarm over entry", bits(types.AreaFlagAlarm, types.AreaFlagEntryTimer, types.AreaFlagArmed), types.AreaStateInAlarm, 0},
+		{"fire alarm while disarmed", bits(types.AreaFlagFireAlarm), types.AreaStateInAlarm, 0},
+		{"duress alarm over part arm", bits(types.AreaFlagDuressAlarm, types.AreaFlagPartArm1), types.AreaStateInAlarm, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, partArm := texecom.ParseAreaFlags(tt.word).State()
+			if state != tt.state || partArm != tt.partArm {
+				t.Errorf("State() = %v, %d, want %v, %d", state, partArm, tt.state, tt.partArm)
+			}
+		})
+	}
+}